@@ -0,0 +1,272 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/mlesar/grafana-report/grafana"
+	"github.com/pborman/uuid"
+)
+
+// BatchFormat selects how the reports of a Batch are packaged
+type BatchFormat int
+
+const (
+	// Zip packages each report as a PDF file in a ZIP archive
+	Zip BatchFormat = iota
+	// CombinedPDF packages the reports as sections of a single PDF
+	CombinedPDF
+)
+
+// Batch generates many reports, e.g. one for each dashboard in a folder.
+// After reading and closing the file returned by Generate(), call Clean() to delete it as well as the temporary build files of all reports
+type Batch struct {
+	reports []Report
+	limiter *Limiter
+	tmpDir  string
+}
+
+const batchZip = "reports.zip"
+
+// Limiter limits the number of reports generated at a time by the batches sharing it
+type Limiter struct {
+	slots chan struct{}
+}
+
+// NewLimiter creates a Limiter allowing n reports at a time. An n below 1 allows one report at a time.
+func NewLimiter(n int) *Limiter {
+	if n < 1 {
+		n = 1
+	}
+	return &Limiter{make(chan struct{}, n)}
+}
+
+// DefaultLimiter is shared by the batches created without a Limiter. It allows a report per CPU at a time.
+var DefaultLimiter = NewLimiter(runtime.NumCPU())
+
+// NewBatch creates a Batch generating reports, as many at a time as limiter allows together with the
+// other batches sharing it. A nil limiter shares DefaultLimiter.
+func NewBatch(reports []Report, limiter *Limiter) *Batch {
+	if limiter == nil {
+		limiter = DefaultLimiter
+	}
+	return &Batch{reports, limiter, filepath.Join("tmp", uuid.New())}
+}
+
+// NewFolderBatch creates a Batch with a Report for each dashboard in the Grafana folder with uid folderUID.
+// The other arguments are used as for NewWithOptions and NewBatch.
+func NewFolderBatch(ctx context.Context, g grafana.Client, folderUID string, time grafana.TimeRange, opts Options, limiter *Limiter) (*Batch, error) {
+	opts = opts.withDefaults()
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	refs, err := g.SearchDashboards(ctx, grafana.SearchQuery{FolderUIDs: []string{folderUID}})
+	if err != nil {
+		return nil, fmt.Errorf("error searching dashboards of folder %v: %w", folderUID, err)
+	}
+	//Grafana releases before 8 ignore the folderUIDs parameter and return every dashboard.
+	//Dashboards of the General folder have no folder uid.
+	var inFolder []grafana.DashboardRef
+	for _, r := range refs {
+		if r.FolderUID == folderUID || (r.FolderUID == "" && folderUID == generalFolderUID) {
+			inFolder = append(inFolder, r)
+		}
+	}
+	return NewBatch(newReports(g, inFolder, time, opts), limiter), nil
+}
+
+// generalFolderUID is the uid Grafana searches the General folder by
+const generalFolderUID = "general"
+
+// Reports returns the reports of the batch
+func (b *Batch) Reports() []Report {
+	return b.reports
+}
+
+// Generate generates all reports and returns them packaged as format. After reading this file it should be Closed().
+// If any report fails, the errors of all failed reports are returned.
+func (b *Batch) Generate(format BatchFormat) (io.ReadCloser, error) {
+	return b.GenerateContext(context.Background(), format)
+}
+
+// GenerateContext is Generate with a context, which cancels the generation of the batch when done.
+// Reports implementing ContextReport are generated within ctx.
+func (b *Batch) GenerateContext(ctx context.Context, format BatchFormat) (io.ReadCloser, error) {
+	pdfs, err := b.generateReports(ctx)
+	defer func() {
+		for _, pdf := range pdfs {
+			if pdf != nil {
+				pdf.Close()
+			}
+		}
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(b.tmpDir, 0777)
+	if err != nil {
+		return nil, fmt.Errorf("creating temporary directory at %v: %w", b.tmpDir, err)
+	}
+	switch format {
+	case Zip:
+		return b.zip(pdfs)
+	case CombinedPDF:
+		return b.combine(ctx, pdfs)
+	default:
+		return nil, fmt.Errorf("unknown batch format %v", format)
+	}
+}
+
+// Clean deletes the temporary directories used during generation of the batch and its reports
+func (b *Batch) Clean() error {
+	var errs []error
+	for _, r := range b.reports {
+		errs = append(errs, r.Clean())
+	}
+	errs = append(errs, os.RemoveAll(b.tmpDir))
+	return errors.Join(errs...)
+}
+
+// generateReports generates the reports concurrently, and returns their pdfs in the order of the reports
+func (b *Batch) generateReports(ctx context.Context) ([]io.ReadCloser, error) {
+	pdfs := make([]io.ReadCloser, len(b.reports))
+	errs := make([]error, len(b.reports))
+	var wg sync.WaitGroup
+	for i, r := range b.reports {
+		wg.Add(1)
+		go func(i int, r Report) {
+			defer wg.Done()
+			select {
+			case b.limiter.slots <- struct{}{}:
+			case <-ctx.Done():
+				errs[i] = fmt.Errorf("generating report %d: %w", i+1, ctx.Err())
+				return
+			}
+			defer func() { <-b.limiter.slots }()
+			if cr, ok := r.(ContextReport); ok {
+				pdfs[i], errs[i] = cr.GenerateContext(ctx)
+			} else {
+				pdfs[i], errs[i] = r.Generate()
+			}
+		}(i, r)
+	}
+	wg.Wait()
+	return pdfs, errors.Join(errs...)
+}
+
+var unsafeFileNameChars = regexp.MustCompile(`[^\w .-]+`)
+
+// fileNames returns a unique PDF file name for each report, based on its title
+func (b *Batch) fileNames() []string {
+	names := make([]string, len(b.reports))
+	used := map[string]bool{}
+	for i, r := range b.reports {
+		//titles are sanitised for TeX, undo the escaping
+		base := strings.TrimSpace(unsafeFileNameChars.ReplaceAllString(strings.Replace(r.Title(), "\\", "", -1), "_"))
+		if base == "" {
+			base = "report"
+		}
+		name := base + ".pdf"
+		for n := 2; used[name]; n++ {
+			name = fmt.Sprintf("%s-%d.pdf", base, n)
+		}
+		used[name] = true
+		names[i] = name
+	}
+	return names
+}
+
+func (b *Batch) zip(pdfs []io.ReadCloser) (io.ReadCloser, error) {
+	path := filepath.Join(b.tmpDir, batchZip)
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("creating zip file at %v: %w", path, err)
+	}
+	defer file.Close()
+
+	w := zip.NewWriter(file)
+	for i, name := range b.fileNames() {
+		entry, err := w.Create(name)
+		if err != nil {
+			return nil, fmt.Errorf("adding %v to zip file: %w", name, err)
+		}
+		if _, err = io.Copy(entry, pdfs[i]); err != nil {
+			return nil, fmt.Errorf("copying %v to zip file: %w", name, err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		return nil, fmt.Errorf("writing zip file: %w", err)
+	}
+	return os.Open(path)
+}
+
+const combinedTemplate = `
+\documentclass{article}
+\usepackage{pdfpages}
+\usepackage[hidelinks]{hyperref}
+\begin{document}
+[[range .]]\includepdf[pages=-,addtotoc={1,section,1,{[[.Title]]},[[.File]]}]{[[.File]]}
+[[end]]\end{document}
+`
+
+// combine includes the report pdfs as sections of a single pdf, using the LaTeX pdfpages package
+func (b *Batch) combine(ctx context.Context, pdfs []io.ReadCloser) (io.ReadCloser, error) {
+	type section struct {
+		Title string
+		File  string
+	}
+	var sections []section
+	for i, r := range b.reports {
+		name := fmt.Sprintf("report%d.pdf", i)
+		file, err := os.Create(filepath.Join(b.tmpDir, name))
+		if err != nil {
+			return nil, fmt.Errorf("creating pdf file for %v: %w", r.Title(), err)
+		}
+		_, err = io.Copy(file, pdfs[i])
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("copying pdf file for %v: %w", r.Title(), err)
+		}
+		sections = append(sections, section{r.Title(), name})
+	}
+
+	texPath := filepath.Join(b.tmpDir, reportTexFile)
+	file, err := os.Create(texPath)
+	if err != nil {
+		return nil, fmt.Errorf("creating tex file at %v: %w", texPath, err)
+	}
+	tmpl := template.Must(template.New("combined").Delims("[[", "]]").Parse(combinedTemplate))
+	err = tmpl.Execute(file, sections)
+	file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("executing combined tex template: %w", err)
+	}
+	return runLaTeX(ctx, b.tmpDir)
+}
@@ -0,0 +1,183 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mlesar/grafana-report/grafana"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+// fakeReport generates its title as pdf, and tracks how many fake reports are generated concurrently
+type fakeReport struct {
+	title string
+	err   error
+
+	mu                  *sync.Mutex
+	running, maxRunning *int
+}
+
+func (f fakeReport) Generate() (io.ReadCloser, error) {
+	f.mu.Lock()
+	*f.running++
+	if *f.running > *f.maxRunning {
+		*f.maxRunning = *f.running
+	}
+	f.mu.Unlock()
+	time.Sleep(5 * time.Millisecond)
+	f.mu.Lock()
+	*f.running--
+	f.mu.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	return ioutil.NopCloser(bytes.NewBufferString("pdf " + f.title)), nil
+}
+
+func (f fakeReport) Title() string {
+	return f.title
+}
+
+func (f fakeReport) Clean() error {
+	return nil
+}
+
+func TestBatch(t *testing.T) {
+	convey.Convey("When generating a batch of reports", t, func(c convey.C) {
+		var mu sync.Mutex
+		running, maxRunning := 0, 0
+		fake := func(title string, err error) Report {
+			return fakeReport{title, err, &mu, &running, &maxRunning}
+		}
+		reports := []Report{fake("Weekly", nil), fake("Weekly", nil), fake("CPU \\& memory", nil), fake("Disks", nil)}
+
+		c.Convey("It should generate at most the reports the limiter allows at a time", func(c convey.C) {
+			batch := NewBatch(reports, NewLimiter(2))
+			defer batch.Clean()
+			zipFile, err := batch.Generate(Zip)
+			c.So(err, convey.ShouldBeNil)
+			zipFile.Close()
+			c.So(maxRunning, convey.ShouldBeBetweenOrEqual, 1, 2)
+		})
+
+		c.Convey("It should package each report as a uniquely named pdf in a zip file", func(c convey.C) {
+			batch := NewBatch(reports, NewLimiter(4))
+			defer batch.Clean()
+			zipFile, err := batch.Generate(Zip)
+			c.So(err, convey.ShouldBeNil)
+			defer zipFile.Close()
+
+			content, _ := ioutil.ReadAll(zipFile)
+			r, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+			c.So(err, convey.ShouldBeNil)
+			var names []string
+			for _, f := range r.File {
+				names = append(names, f.Name)
+			}
+			c.So(names, convey.ShouldResemble, []string{"Weekly.pdf", "Weekly-2.pdf", "CPU _ memory.pdf", "Disks.pdf"})
+
+			entry, _ := r.File[3].Open()
+			pdf, _ := ioutil.ReadAll(entry)
+			c.So(string(pdf), convey.ShouldEqual, "pdf Disks")
+		})
+
+		c.Convey("Batches sharing a limiter should generate at most its limit of reports together", func(c convey.C) {
+			limiter := NewLimiter(2)
+			var wg sync.WaitGroup
+			for i := 0; i < 3; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					batch := NewBatch(reports, limiter)
+					defer batch.Clean()
+					if zipFile, err := batch.Generate(Zip); err == nil {
+						zipFile.Close()
+					}
+				}()
+			}
+			wg.Wait()
+			c.So(maxRunning, convey.ShouldBeBetweenOrEqual, 1, 2)
+			c.So(NewBatch(reports, nil).limiter, convey.ShouldEqual, DefaultLimiter)
+		})
+
+		c.Convey("It should not generate reports once the context is done", func(c convey.C) {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			batch := NewBatch(reports, NewLimiter(0))
+			defer batch.Clean()
+			limiterFull := batch.limiter.slots
+			limiterFull <- struct{}{}
+			defer func() { <-limiterFull }()
+			_, err := batch.GenerateContext(ctx, Zip)
+			c.So(errors.Is(err, context.Canceled), convey.ShouldBeTrue)
+			c.So(maxRunning, convey.ShouldEqual, 0)
+		})
+
+		c.Convey("It should return the errors of all failed reports", func(c convey.C) {
+			batch := NewBatch([]Report{fake("a", errors.New("first failure")), fake("b", nil), fake("c", errors.New("second failure"))}, nil)
+			defer batch.Clean()
+			_, err := batch.Generate(Zip)
+			c.So(err, convey.ShouldNotBeNil)
+			c.So(err.Error(), convey.ShouldContainSubstring, "first failure")
+			c.So(err.Error(), convey.ShouldContainSubstring, "second failure")
+		})
+	})
+
+	convey.Convey("When creating a batch for a folder", t, func(c convey.C) {
+		gClient := &folderClient{mockGrafanaClient{0, url.Values{}}}
+
+		c.Convey("It should create a report per dashboard of the folder", func(c convey.C) {
+			batch, err := NewFolderBatch(context.Background(), gClient, "folder", grafana.TimeRange{From: "now-7d", To: "now"}, Options{}, nil)
+			c.So(err, convey.ShouldBeNil)
+			c.So(batch.Reports(), convey.ShouldHaveLength, 2)
+			c.So(batch.Reports()[1].(*report).dashName, convey.ShouldEqual, "cpu")
+			c.So(batch.limiter, convey.ShouldEqual, DefaultLimiter)
+		})
+
+		c.Convey("Dashboards without a folder should be in the General folder", func(c convey.C) {
+			batch, err := NewFolderBatch(context.Background(), gClient, "general", grafana.TimeRange{From: "now-7d", To: "now"}, Options{}, nil)
+			c.So(err, convey.ShouldBeNil)
+			c.So(batch.Reports(), convey.ShouldHaveLength, 1)
+			c.So(batch.Reports()[0].(*report).dashName, convey.ShouldEqual, "home")
+		})
+	})
+}
+
+// folderClient searches like Grafana releases before folder uid search, which return every dashboard
+type folderClient struct {
+	mockGrafanaClient
+}
+
+func (f *folderClient) SearchDashboards(ctx context.Context, q grafana.SearchQuery) ([]grafana.DashboardRef, error) {
+	return []grafana.DashboardRef{
+		{Name: "testDash", FolderUID: "folder"},
+		{Name: "other", FolderUID: "other"},
+		{Name: "home"},
+		{Name: "cpu", FolderUID: "folder"},
+	}, nil
+}
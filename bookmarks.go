@@ -0,0 +1,76 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mlesar/grafana-report/grafana"
+)
+
+// bookmarks tracks the dashboard rows bookmarked while a template executes
+type bookmarks struct {
+	rows map[int]bool
+}
+
+// Bookmark returns the LaTeX that bookmarks panel p in the PDF and adds it to the table of contents.
+// The first panel bookmarked of each dashboard row also bookmarks the row, and the panels of a row are nested in it.
+func (d templateData) Bookmark(p grafana.Panel) string {
+	level := "section"
+	var b strings.Builder
+	if p.Row > 0 {
+		if d.bookmarks == nil || !d.bookmarks.rows[p.Row] {
+			b.WriteString(contentsLine("section", rowTitle(p)))
+		}
+		if d.bookmarks != nil {
+			if d.bookmarks.rows == nil {
+				d.bookmarks.rows = map[int]bool{}
+			}
+			d.bookmarks.rows[p.Row] = true
+		}
+		level = "subsection"
+	}
+	b.WriteString(contentsLine(level, panelTitle(p)))
+	return b.String()
+}
+
+// Keywords returns the dashboard tags as the keywords of the PDF metadata
+func (d templateData) Keywords() string {
+	return strings.Join(d.Tags, ", ")
+}
+
+// contentsLine returns the LaTeX that adds title to the table of contents, and so to the PDF bookmarks, at level
+func contentsLine(level string, title string) string {
+	return fmt.Sprintf("\\phantomsection\\addcontentsline{toc}{%s}{%s}\n", level, title)
+}
+
+// rowTitle returns the title of the row of p, or a title made of its position if it has none
+func rowTitle(p grafana.Panel) string {
+	if p.RowTitle == "" {
+		return fmt.Sprintf("Row %d", p.Row)
+	}
+	return p.RowTitle
+}
+
+// panelTitle returns the title of p, or a title made of its id if it has none
+func panelTitle(p grafana.Panel) string {
+	if p.Title == "" {
+		return fmt.Sprintf("Panel %d", p.Id)
+	}
+	return p.Title
+}
@@ -0,0 +1,82 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mlesar/grafana-report/grafana"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestBookmarks(t *testing.T) {
+	convey.Convey("When bookmarking panels", t, func(c convey.C) {
+		data := templateData{bookmarks: &bookmarks{}}
+
+		c.Convey("Panels without a row should be top level bookmarks", func(c convey.C) {
+			c.So(data.Bookmark(grafana.Panel{Id: 1, Title: "CPU"}), convey.ShouldEqual, "\\phantomsection\\addcontentsline{toc}{section}{CPU}\n")
+			c.So(data.Bookmark(grafana.Panel{Id: 2}), convey.ShouldEqual, "\\phantomsection\\addcontentsline{toc}{section}{Panel 2}\n")
+		})
+
+		c.Convey("The first panel of a row should bookmark the row, and panels should nest in their row", func(c convey.C) {
+			first := data.Bookmark(grafana.Panel{Id: 1, Title: "Reads", RowTitle: "Disks", Row: 1})
+			second := data.Bookmark(grafana.Panel{Id: 2, Title: "Writes", RowTitle: "Disks", Row: 1})
+			c.So(first, convey.ShouldEqual, "\\phantomsection\\addcontentsline{toc}{section}{Disks}\n"+
+				"\\phantomsection\\addcontentsline{toc}{subsection}{Reads}\n")
+			c.So(second, convey.ShouldEqual, "\\phantomsection\\addcontentsline{toc}{subsection}{Writes}\n")
+		})
+
+		c.Convey("Rows should be told apart by position, not title, and untitled rows should be bookmarked", func(c convey.C) {
+			c.So(data.Bookmark(grafana.Panel{Id: 1, Title: "Reads", RowTitle: "Disks", Row: 1}), convey.ShouldStartWith, "\\phantomsection\\addcontentsline{toc}{section}{Disks}\n")
+			c.So(data.Bookmark(grafana.Panel{Id: 2, Title: "Reads", RowTitle: "Disks", Row: 2}), convey.ShouldStartWith, "\\phantomsection\\addcontentsline{toc}{section}{Disks}\n")
+			c.So(data.Bookmark(grafana.Panel{Id: 3, Title: "Uptime", Row: 3}), convey.ShouldEqual, "\\phantomsection\\addcontentsline{toc}{section}{Row 3}\n"+
+				"\\phantomsection\\addcontentsline{toc}{subsection}{Uptime}\n")
+		})
+	})
+
+	convey.Convey("When generating a report", t, func(c convey.C) {
+		dash := grafana.Dashboard{Title: "Storage \\& disks", Tags: []string{"storage", "weekly"}, Panels: []grafana.Panel{
+			{Id: 1, Title: "Reads", RowTitle: "Disks", Row: 1, GridPos: grafana.GridPos{W: 12, H: 8}},
+			{Id: 2, Title: "Writes", RowTitle: "Disks", Row: 1, GridPos: grafana.GridPos{X: 12, W: 12, H: 8}},
+		}}
+
+		for _, gridLayout := range []bool{false, true} {
+			c.Convey("The default templates should set the PDF metadata and bookmark panels, with grid layout "+map[bool]string{false: "off", true: "on"}[gridLayout], func(c convey.C) {
+				rep, tex, err := generateTestTeX(c, dash, Options{GridLayout: gridLayout, TableOfContents: true, Branding: Branding{Company: "ACME"}})
+				defer rep.Clean()
+				c.So(err, convey.ShouldBeNil)
+				c.So(tex, convey.ShouldContainSubstring, `\usepackage[hidelinks,bookmarksopen]{hyperref}`)
+				c.So(tex, convey.ShouldContainSubstring, `pdftitle={Storage \& disks}`)
+				c.So(tex, convey.ShouldContainSubstring, `pdfsubject={`+rep.time.FromFormatted()+` to `+rep.time.ToFormatted()+`}`)
+				c.So(tex, convey.ShouldContainSubstring, `pdfauthor={ACME}`)
+				c.So(tex, convey.ShouldContainSubstring, `pdfkeywords={storage, weekly}`)
+				c.So(tex, convey.ShouldContainSubstring, `\tableofcontents`)
+				c.So(strings.Count(tex, `\addcontentsline{toc}{section}{Disks}`), convey.ShouldEqual, 1)
+				c.So(tex, convey.ShouldContainSubstring, `\addcontentsline{toc}{subsection}{Writes}`)
+			})
+		}
+
+		c.Convey("Reports should not have a table of contents by default", func(c convey.C) {
+			rep, tex, err := generateTestTeX(c, dash, Options{Author: "Ops"})
+			defer rep.Clean()
+			c.So(err, convey.ShouldBeNil)
+			c.So(tex, convey.ShouldNotContainSubstring, `\tableofcontents`)
+			c.So(tex, convey.ShouldContainSubstring, `pdfauthor={Ops}`)
+		})
+	})
+}
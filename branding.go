@@ -0,0 +1,98 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Branding is the corporate identity printed on every page of a report.
+// Templates include it with [[template "branding" .]] in the preamble, as the default templates do.
+// Text is escaped for LaTeX by the templates.
+type Branding struct {
+	// Logo is the path of a png, jpg or pdf logo image, shown at the left of the header
+	Logo string
+	// Company is the company name, shown in the header next to the logo
+	Company string
+	// Header is shown at the right of the header
+	Header string
+	// Footer is shown at the left of the footer. The right of the footer shows "Page X of Y".
+	Footer string
+	// Watermark is printed across every page, e.g. Confidential
+	Watermark string
+	// Accent is the colour of the company name and the header rule, in the HTML notation of the xcolor package, e.g. 1F60C4.
+	// It defaults to the text colour of the theme.
+	Accent string
+}
+
+// logoFile is the name of the logo in the build directory, without the extension of the logo image
+const logoFile = "logo"
+
+// logoExtensions are the extensions of the logo images pdflatex can include
+var logoExtensions = map[string]bool{".png": true, ".jpg": true, ".jpeg": true, ".pdf": true}
+
+// IsSet reports whether the report is branded. A report with only an Accent colour is not.
+func (b Branding) IsSet() bool {
+	return b.Logo != "" || b.Company != "" || b.Header != "" || b.Footer != "" || b.Watermark != ""
+}
+
+// withDefaults fills in the Accent colour from the theme
+func (b Branding) withDefaults(t Theme) Branding {
+	if b.Accent == "" {
+		b.Accent = t.Text
+	}
+	b.Accent = strings.TrimPrefix(b.Accent, "#")
+	return b
+}
+
+// validate checks the Accent colour, filled in by withDefaults, and the extension of the Logo
+func (b Branding) validate() error {
+	if b.Logo != "" && !logoExtensions[strings.ToLower(filepath.Ext(b.Logo))] {
+		return fmt.Errorf("logo %q is not a png, jpg or pdf image", b.Logo)
+	}
+	return checkColour("branding accent", b.Accent)
+}
+
+// copyLogo copies the logo image into the build directory next to the images directory.
+// It returns the branding the template is executed with, which refers to the copy.
+func (rep *report) copyLogo() (Branding, error) {
+	b := rep.opts.Branding
+	if b.Logo == "" {
+		return b, nil
+	}
+	src, err := os.Open(b.Logo)
+	if err != nil {
+		return b, fmt.Errorf("opening logo: %w", err)
+	}
+	defer src.Close()
+
+	name := logoFile + strings.ToLower(filepath.Ext(b.Logo))
+	dst, err := os.Create(filepath.Join(rep.tmpDir, name))
+	if err != nil {
+		return b, fmt.Errorf("creating logo file: %w", err)
+	}
+	defer dst.Close()
+	if _, err := io.Copy(dst, src); err != nil {
+		return b, fmt.Errorf("copying logo: %w", err)
+	}
+	b.Logo = logoFile
+	return b, nil
+}
@@ -0,0 +1,120 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mlesar/grafana-report/grafana"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestBranding(t *testing.T) {
+	convey.Convey("When generating a branded report", t, func(c convey.C) {
+		logoDir, err := ioutil.TempDir("", "logo")
+		c.So(err, convey.ShouldBeNil)
+		defer os.RemoveAll(logoDir)
+		logo := filepath.Join(logoDir, "acme.PNG")
+		ioutil.WriteFile(logo, []byte("logo"), 0666)
+
+		gClient := &mockGrafanaClient{0, url.Values{}}
+		dash := testDashboard()
+		generate := func(opts Options) (*report, string, error) {
+			return generateTestTeX(c, dash, opts)
+		}
+
+		for _, gridLayout := range []bool{false, true} {
+			c.Convey("The default template should print the branding, with grid layout "+map[bool]string{false: "off", true: "on"}[gridLayout], func(c convey.C) {
+				branding := Branding{Logo: logo, Company: "ACME & Co", Header: "Weekly report", Footer: "Internal use only", Watermark: "Confidential", Accent: "#1F60C4"}
+				rep, tex, err := generate(Options{GridLayout: gridLayout, Branding: branding})
+				defer rep.Clean()
+				c.So(err, convey.ShouldBeNil)
+				c.So(tex, convey.ShouldContainSubstring, `\definecolor{accent}{HTML}{1F60C4}`)
+				c.So(tex, convey.ShouldContainSubstring, `\includegraphics[height=24pt]{logo}`)
+				c.So(tex, convey.ShouldContainSubstring, `\textcolor{accent}{\textbf{ACME \& Co}}`)
+				c.So(tex, convey.ShouldContainSubstring, `\fancyhead[R]{Weekly report}`)
+				c.So(tex, convey.ShouldContainSubstring, `\fancyfoot[L]{Internal use only}`)
+				c.So(tex, convey.ShouldContainSubstring, `\fancyfoot[R]{Page \thepage\ of \pageref{LastPage}}`)
+				c.So(tex, convey.ShouldContainSubstring, `\SetWatermarkText{Confidential}`)
+			})
+		}
+
+		c.Convey("It should copy the logo next to the images, where the template includes it from", func(c convey.C) {
+			rep, tex, err := generate(Options{Branding: Branding{Logo: logo}})
+			defer rep.Clean()
+			c.So(err, convey.ShouldBeNil)
+			c.So(rep.renderPanelsParallel(context.Background(), dash), convey.ShouldBeNil)
+			c.So(tex, convey.ShouldContainSubstring, `{logo}`)
+			copied, err := ioutil.ReadFile(filepath.Join(filepath.Dir(rep.imgDirPath()), logoFile+".png"))
+			c.So(err, convey.ShouldBeNil)
+			c.So(string(copied), convey.ShouldEqual, "logo")
+			entries, err := os.ReadDir(filepath.Dir(rep.texPath()))
+			c.So(err, convey.ShouldBeNil)
+			var names []string
+			for _, e := range entries {
+				names = append(names, e.Name())
+			}
+			c.So(names, convey.ShouldResemble, []string{imgDir, "logo.png", reportTexFile})
+		})
+
+		c.Convey("It should reject logos pdflatex cannot include", func(c convey.C) {
+			_, err := NewWithOptions(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, Options{Branding: Branding{Logo: filepath.Join(logoDir, "acme.svg")}})
+			c.So(err, convey.ShouldNotBeNil)
+			rep := newTestReport(c, gClient, Options{Branding: Branding{Logo: filepath.Join(logoDir, "acme.JPEG")}})
+			c.So(rep.opts.Branding.Logo, convey.ShouldEndWith, "acme.JPEG")
+		})
+
+		c.Convey("It should reject accent colours that are not 6 hexadecimal digits", func(c convey.C) {
+			_, err := NewWithOptions(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, Options{Branding: Branding{Accent: "blue"}})
+			c.So(err, convey.ShouldNotBeNil)
+		})
+
+		c.Convey("It should fail if the logo does not exist", func(c convey.C) {
+			rep, _, err := generate(Options{Branding: Branding{Logo: filepath.Join(logoDir, "missing.png")}})
+			defer rep.Clean()
+			c.So(err, convey.ShouldNotBeNil)
+		})
+
+		c.Convey("The accent colour should default to the theme text colour", func(c convey.C) {
+			rep, tex, err := generate(Options{Theme: DarkTheme, Branding: Branding{Company: "ACME"}})
+			defer rep.Clean()
+			c.So(err, convey.ShouldBeNil)
+			c.So(tex, convey.ShouldContainSubstring, `\definecolor{accent}{HTML}{CCCCDC}`)
+			c.So(tex, convey.ShouldNotContainSubstring, "draftwatermark")
+		})
+
+		c.Convey("Reports without branding should not load the branding packages", func(c convey.C) {
+			rep, tex, err := generate(Options{Branding: Branding{Accent: "1F60C4"}})
+			defer rep.Clean()
+			c.So(err, convey.ShouldBeNil)
+			c.So(tex, convey.ShouldNotContainSubstring, "fancyhdr")
+		})
+
+		c.Convey("Template partials should be able to override the branding", func(c convey.C) {
+			tmpl := NewTemplate("custom", `[[template "branding" .]]`, `[[define "branding"]]custom branding[[end]]`)
+			rep, tex, err := generate(Options{Template: tmpl, Branding: Branding{Company: "ACME"}})
+			defer rep.Clean()
+			c.So(err, convey.ShouldBeNil)
+			c.So(tex, convey.ShouldEqual, "custom branding")
+		})
+	})
+}
@@ -0,0 +1,56 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"strings"
+
+	"github.com/mlesar/grafana-report/grafana"
+)
+
+// panelData is the data of the caption partial: a panel, and the data of the report it is in
+type panelData struct {
+	grafana.Panel
+	Report templateData
+}
+
+// WithPanel returns the data partials about panel p are executed with
+func (d templateData) WithPanel(p grafana.Panel) panelData {
+	return panelData{Panel: p, Report: d}
+}
+
+// Caption returns the figure caption of panel p: its title and description, followed by its unit and,
+// if it shows another time range than the dashboard, its time range.
+// The caption is one line, as \captionof fails on the paragraph breaks of multiline descriptions.
+func (d templateData) Caption(p grafana.Panel) string {
+	caption := panelTitle(p)
+	if p.Description != "" {
+		caption += " --- " + p.Description
+	}
+	var details []string
+	if p.Unit != "" {
+		details = append(details, "unit: "+p.Unit)
+	}
+	if p.HasTimeOverride() && !p.HideTimeOverride {
+		t := p.EffectiveTimeRange(d.TimeRange)
+		details = append(details, t.FromFormatted()+" to "+t.ToFormatted())
+	}
+	if len(details) > 0 {
+		caption += " (" + strings.Join(details, ", ") + ")"
+	}
+	return strings.Join(strings.Fields(caption), " ")
+}
@@ -0,0 +1,84 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mlesar/grafana-report/grafana"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestCaptions(t *testing.T) {
+	convey.Convey("When captioning panels", t, func(c convey.C) {
+		data := templateData{TimeRange: grafana.TimeRange{From: "1500000000000", To: "1500003600000"}}
+
+		c.Convey("The caption should contain the title, description and unit", func(c convey.C) {
+			p := grafana.Panel{Id: 3, Title: "CPU usage", Description: "Busy time of all cores", Unit: "percent"}
+			c.So(data.Caption(p), convey.ShouldEqual, "CPU usage --- Busy time of all cores (unit: percent)")
+		})
+
+		c.Convey("The caption should contain overridden time ranges", func(c convey.C) {
+			p := grafana.Panel{Id: 3, TimeShift: "1h"}
+			shifted := p.EffectiveTimeRange(data.TimeRange)
+			c.So(data.Caption(p), convey.ShouldEqual, "Panel 3 ("+shifted.FromFormatted()+" to "+shifted.ToFormatted()+")")
+
+			p.HideTimeOverride = true
+			c.So(data.Caption(p), convey.ShouldEqual, "Panel 3")
+		})
+
+		c.Convey("The caption should be one line", func(c convey.C) {
+			p := grafana.Panel{Id: 3, Title: "CPU usage", Description: "Busy time\n\nof all  cores\r\n"}
+			c.So(data.Caption(p), convey.ShouldEqual, "CPU usage --- Busy time of all cores")
+		})
+
+		c.Convey("Template partials should be able to override the caption", func(c convey.C) {
+			tmpl := NewTemplate("custom", `[[range .Panels]][[template "caption" ($.WithPanel .)]][[end]]`,
+				`[[define "caption"]]<[[.Id]] [[.Report.Caption .Panel]]>[[end]]`)
+			dash := grafana.Dashboard{Panels: []grafana.Panel{{Id: 1, Title: "CPU"}, {Id: 2}}}
+			rep, tex, err := generateTestTeX(c, dash, Options{Template: tmpl})
+			defer rep.Clean()
+			c.So(err, convey.ShouldBeNil)
+			c.So(tex, convey.ShouldEqual, "<1 CPU><2 Panel 2>")
+		})
+
+		c.Convey("The default templates should caption panels and list the figures", func(c convey.C) {
+			for _, gridLayout := range []bool{false, true} {
+				dash := grafana.Dashboard{Title: "Captions", Panels: []grafana.Panel{
+					{Id: 1, Type: "graph", Title: "CPU usage", Description: "All cores", GridPos: grafana.GridPos{W: 24, H: 8}},
+					{Id: 2, Type: "graph", GridPos: grafana.GridPos{Y: 8, W: 24, H: 8}},
+				}}
+				rep, tex, err := generateTestTeX(c, dash, Options{GridLayout: gridLayout, ListOfFigures: true})
+				defer rep.Clean()
+				c.So(err, convey.ShouldBeNil)
+				c.So(tex, convey.ShouldContainSubstring, `\usepackage{caption}`)
+				c.So(tex, convey.ShouldContainSubstring, `\listoffigures`)
+				c.So(tex, convey.ShouldContainSubstring, `\captionof{figure}[{CPU usage}]{CPU usage --- All cores}`)
+				c.So(tex, convey.ShouldContainSubstring, `\captionof{figure}[{Panel 2}]{Panel 2}`)
+			}
+		})
+
+		c.Convey("Reports should not caption panels by default", func(c convey.C) {
+			rep, tex, err := generateTestTeX(c, testDashboard(), Options{})
+			defer rep.Clean()
+			c.So(err, convey.ShouldBeNil)
+			c.So(strings.Contains(tex, "caption"), convey.ShouldBeFalse)
+			c.So(strings.Contains(tex, `\listoffigures`), convey.ShouldBeFalse)
+		})
+	})
+}
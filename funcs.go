@@ -0,0 +1,155 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/mlesar/grafana-report/grafana"
+)
+
+// templateFuncs returns the functions available to templates of reports on dash:
+//
+//	texescape s            escapes the LaTeX special characters of s
+//	date layout zone t     formats the time t, e.g. .FromTime, with a Go layout in the IANA zone, or the local zone if empty
+//	add a b, mul a b       adds or multiplies numbers, e.g. mul .Width 0.9
+//	chunk n panels         splits panels into lists of at most n panels, e.g. to lay out n panels per row
+//	panelsInRow panels     groups panels into the rows of grid, i.e. panels overlapping vertically, ordered from left to right
+//	grid panels            lays panels out as on the dashboard grid, as a list of GridRow of positioned cells, see also .GridRows
+//	default def v          returns v, or def if v is empty
+//	upper s                upper cases s with \MakeUppercase, which keeps the LaTeX escapes of s intact
+//	variable name          returns the LaTeX escaped values of the template variable, e.g. variable "host"
+//	now                    returns the current time
+//	env name               returns the environment variable name, which must start with REPORT_
+func templateFuncs(dash grafana.Dashboard) template.FuncMap {
+	return template.FuncMap{
+		"texescape": grafana.EscapeLaTeX,
+		"date":      formatDate,
+		"add": func(a, b interface{}) (float64, error) {
+			return arith(a, b, func(x, y float64) float64 { return x + y })
+		},
+		"mul": func(a, b interface{}) (float64, error) {
+			return arith(a, b, func(x, y float64) float64 { return x * y })
+		},
+		"chunk":       chunk,
+		"panelsInRow": panelsInRow,
+		"grid":        func(panels []grafana.Panel) []GridRow { return layoutGrid(panels, Continuous) },
+		"default":     defaultValue,
+		"upper":       func(s string) string { return `\MakeUppercase{` + s + "}" },
+		"variable":    func(name string) string { return variable(dash, name) },
+		"now":         time.Now,
+		"env":         env,
+	}
+}
+
+// envPrefix is the prefix of the environment variables templates can read, so that templates cannot read
+// secrets such as Grafana API tokens from the environment
+const envPrefix = "REPORT_"
+
+func env(name string) (string, error) {
+	if !strings.HasPrefix(name, envPrefix) {
+		return "", fmt.Errorf("environment variable %s is not readable by templates, only %s variables are", name, envPrefix)
+	}
+	return os.Getenv(name), nil
+}
+
+func formatDate(layout string, zone string, t time.Time) (string, error) {
+	if zone != "" {
+		loc, err := time.LoadLocation(zone)
+		if err != nil {
+			return "", err
+		}
+		t = t.In(loc)
+	}
+	return t.Format(layout), nil
+}
+
+func arith(a, b interface{}, op func(x, y float64) float64) (float64, error) {
+	x, err := toFloat(a)
+	if err != nil {
+		return 0, err
+	}
+	y, err := toFloat(b)
+	if err != nil {
+		return 0, err
+	}
+	return op(x, y), nil
+}
+
+func toFloat(v interface{}) (float64, error) {
+	r := reflect.ValueOf(v)
+	switch r.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(r.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(r.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return r.Float(), nil
+	default:
+		return 0, fmt.Errorf("%v is not a number", v)
+	}
+}
+
+func chunk(n int, panels []grafana.Panel) ([][]grafana.Panel, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("chunk size %d is not positive", n)
+	}
+	var chunks [][]grafana.Panel
+	for len(panels) > n {
+		chunks = append(chunks, panels[:n])
+		panels = panels[n:]
+	}
+	if len(panels) > 0 {
+		chunks = append(chunks, panels)
+	}
+	return chunks, nil
+}
+
+// panelsInRow returns the panels of the rows that grid lays out, so that both agree on what a row is
+func panelsInRow(panels []grafana.Panel) [][]grafana.Panel {
+	var rows [][]grafana.Panel
+	for _, row := range layoutGrid(panels, Continuous) {
+		var inRow []grafana.Panel
+		for _, cell := range row.Cells {
+			inRow = append(inRow, cell.Panels...)
+		}
+		rows = append(rows, inRow)
+	}
+	return rows
+}
+
+func defaultValue(def interface{}, v interface{}) interface{} {
+	if v == nil {
+		return def
+	}
+	if r := reflect.ValueOf(v); r.IsZero() || ((r.Kind() == reflect.Slice || r.Kind() == reflect.Map) && r.Len() == 0) {
+		return def
+	}
+	return v
+}
+
+func variable(dash grafana.Dashboard, name string) string {
+	if !strings.HasPrefix(name, "var-") {
+		name = "var-" + name
+	}
+	return grafana.EscapeLaTeX(strings.Join(dash.Variables[name], ", "))
+}
@@ -0,0 +1,143 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"bytes"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mlesar/grafana-report/grafana"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestTemplateFuncs(t *testing.T) {
+	convey.Convey("When executing templates with the template functions", t, func(c convey.C) {
+		variables := url.Values{}
+		variables.Add("var-host", "web_1")
+		variables.Add("var-host", "web_2")
+		dash := grafana.NewDashboard([]byte(dashJSON), variables)
+		data := struct {
+			grafana.Dashboard
+			grafana.TimeRange
+		}{dash, grafana.TimeRange{From: "1500000000000", To: "now"}}
+
+		execute := func(text string) (string, error) {
+			tmpl, err := parseTemplate("test", text, templateFuncs(dash))
+			if err != nil {
+				return "", err
+			}
+			var buf bytes.Buffer
+			err = tmpl.Execute(&buf, data)
+			return buf.String(), err
+		}
+
+		c.Convey("texescape should escape LaTeX special characters", func(c convey.C) {
+			out, err := execute(`[[texescape "50% & more"]]`)
+			c.So(err, convey.ShouldBeNil)
+			c.So(out, convey.ShouldEqual, `50\% \& more`)
+		})
+
+		c.Convey("date should format times in a zone", func(c convey.C) {
+			out, err := execute(`[[date "2006-01-02 15:04" "UTC" .FromTime]]`)
+			c.So(err, convey.ShouldBeNil)
+			c.So(out, convey.ShouldEqual, "2017-07-14 02:40")
+
+			_, err = execute(`[[date "2006" "Nowhere/Land" .FromTime]]`)
+			c.So(err, convey.ShouldNotBeNil)
+		})
+
+		c.Convey("add and mul should do arithmetic on numbers", func(c convey.C) {
+			out, err := execute(`[[add 1 2]] [[mul 0.5 3]] [[add (mul 2 2) 1]]`)
+			c.So(err, convey.ShouldBeNil)
+			c.So(out, convey.ShouldEqual, "3 1.5 5")
+
+			_, err = execute(`[[add 1 "two"]]`)
+			c.So(err, convey.ShouldNotBeNil)
+		})
+
+		c.Convey("chunk should split panels into lists of at most n panels", func(c convey.C) {
+			out, err := execute(`[[range chunk 4 .Panels]][[len .]] [[end]]`)
+			c.So(err, convey.ShouldBeNil)
+			c.So(out, convey.ShouldEqual, "4 4 1 ")
+
+			_, err = execute(`[[chunk 0 .Panels]]`)
+			c.So(err, convey.ShouldNotBeNil)
+		})
+
+		c.Convey("panelsInRow should group panels by grid row", func(c convey.C) {
+			panels := []grafana.Panel{
+				{Id: 1, GridPos: grafana.GridPos{H: 8, W: 12, X: 12, Y: 0}},
+				{Id: 2, GridPos: grafana.GridPos{H: 8, W: 24, X: 0, Y: 8}},
+				{Id: 3, GridPos: grafana.GridPos{H: 8, W: 12, X: 0, Y: 0}},
+			}
+			rows := panelsInRow(panels)
+			c.So(rows, convey.ShouldHaveLength, 2)
+			c.So(rows[0][0].Id, convey.ShouldEqual, 3)
+			c.So(rows[0][1].Id, convey.ShouldEqual, 1)
+			c.So(rows[1][0].Id, convey.ShouldEqual, 2)
+		})
+
+		c.Convey("panelsInRow should group panels into the same rows as grid", func(c convey.C) {
+			panels := []grafana.Panel{
+				{Id: 1, GridPos: grafana.GridPos{H: 8, W: 12, X: 0, Y: 0}},
+				{Id: 2, GridPos: grafana.GridPos{H: 4, W: 12, X: 12, Y: 4}},
+				{Id: 3, GridPos: grafana.GridPos{H: 4, W: 24, X: 0, Y: 8}},
+			}
+			rows := panelsInRow(panels)
+			c.So(rows, convey.ShouldHaveLength, len(layoutGrid(panels, Continuous)))
+			c.So(rows[0], convey.ShouldHaveLength, 2)
+			c.So(rows[1][0].Id, convey.ShouldEqual, 3)
+		})
+
+		c.Convey("default, upper and env should transform values", func(c convey.C) {
+			os.Setenv("REPORT_TEST_ENV", "from env")
+			defer os.Unsetenv("REPORT_TEST_ENV")
+			out, err := execute(`[[default "none" ""]] [[default "none" "some"]] [[upper .Title]] [[env "REPORT_TEST_ENV"]]`)
+			c.So(err, convey.ShouldBeNil)
+			c.So(out, convey.ShouldEqual, `none some \MakeUppercase{My first dashboard} from env`)
+		})
+
+		c.Convey("upper should keep LaTeX escapes intact", func(c convey.C) {
+			out, err := execute(`[[upper (texescape "a~b\\c")]]`)
+			c.So(err, convey.ShouldBeNil)
+			c.So(out, convey.ShouldEqual, `\MakeUppercase{a\textasciitilde b\textbackslash c}`)
+		})
+
+		c.Convey("env should only read REPORT_ variables", func(c convey.C) {
+			os.Setenv("GRAFANA_TEST_TOKEN", "secret")
+			defer os.Unsetenv("GRAFANA_TEST_TOKEN")
+			out, err := execute(`[[env "GRAFANA_TEST_TOKEN"]]`)
+			c.So(err, convey.ShouldNotBeNil)
+			c.So(out, convey.ShouldNotContainSubstring, "secret")
+		})
+
+		c.Convey("variable should return the escaped values of a template variable", func(c convey.C) {
+			out, err := execute(`[[variable "host"]]|[[variable "var-host"]]|[[variable "missing"]]`)
+			c.So(err, convey.ShouldBeNil)
+			c.So(out, convey.ShouldEqual, `web\_1, web\_2|web\_1, web\_2|`)
+		})
+
+		c.Convey("now should return the current time", func(c convey.C) {
+			out, err := execute(`[[date "2006" "" now]]`)
+			c.So(err, convey.ShouldBeNil)
+			c.So(out, convey.ShouldEqual, time.Now().Format("2006"))
+		})
+	})
+}
@@ -17,32 +17,109 @@
 package grafana
 
 import (
-	"crypto/tls"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
 	"strconv"
+	"sync"
 	"time"
+
+	"github.com/mlesar/grafana-report/metrics"
+	"github.com/mlesar/grafana-report/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// Client is a Grafana API client
+// Client is a Grafana API client.
+// The trace context of ctx is propagated to Grafana in the W3C traceparent header.
 type Client interface {
-	GetDashboard(dashName string) (Dashboard, error)
-	GetPanelPng(p Panel, dashName string, t TimeRange) (io.ReadCloser, error)
+	GetDashboard(ctx context.Context, dashName string) (Dashboard, error)
+	// RenderPanel renders the panel in the first of the formats of opts supported by Grafana, falling back to PNG
+	RenderPanel(ctx context.Context, p Panel, dashName string, t TimeRange, opts RenderOptions) (io.ReadCloser, ImageFormat, error)
+	SearchDashboards(ctx context.Context, q SearchQuery) ([]DashboardRef, error)
 }
 
 type client struct {
-	url              string
-	getDashEndpoint  func(dashName string) string
-	getPanelEndpoint func(dashName string, vals url.Values) string
-	apiToken         string
-	variables        url.Values
-	sslCheck         bool
-	gridLayout       bool
+	url                    string
+	getDashEndpoint        func(dashName string) string
+	getDashVersionEndpoint func(dashName string) string
+	getPanelEndpoint       func(dashName string, vals url.Values) string
+	auth                   Authenticator
+	variables              url.Values
+	httpClient             *http.Client
+	gridLayout             bool
+	renderValues           url.Values //added to every render request, e.g. orgId
+	slugNames              bool       //dashboards are named by slug rather than uid
+	scale                  float64
+	scaleParam             bool //the scale is passed to Grafana rather than applied to the pixel dimensions
+	formats                *formatSupport
+}
+
+// Themes for RenderOptions.Theme
+const (
+	LightTheme = "light"
+	DarkTheme  = "dark"
+)
+
+// RenderOptions configures how a panel is rendered
+type RenderOptions struct {
+	// Theme is the Grafana theme the panel is rendered in, LightTheme, DarkTheme or, from Grafana 10,
+	// the id of a custom theme. Empty renders the light theme.
+	Theme string
+	// Formats are the image formats acceptable to the caller, in order of preference.
+	// Formats other than PNG are requested with the encoding parameter supported by recent image renderers,
+	// and PNG is used if the renderer fails them. A client does not request formats its renderer failed again.
+	// Empty renders PNG.
+	Formats []ImageFormat
+}
+
+func (o RenderOptions) formats() []ImageFormat {
+	return append(append([]ImageFormat{}, o.Formats...), PNG)
+}
+
+// ImageFormat is the format of a rendered panel, named by its file extension
+type ImageFormat string
+
+// Image formats for RenderOptions.Formats
+const (
+	PNG ImageFormat = "png"
+	PDF ImageFormat = "pdf"
+	SVG ImageFormat = "svg"
+)
+
+// sniffFormat detects the format of a render from its first bytes, because renderers that do not
+// support an encoding may ignore it
+func sniffFormat(body io.ReadCloser) (io.ReadCloser, ImageFormat, error) {
+	r := bufio.NewReader(body)
+	head, err := r.Peek(512)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		body.Close()
+		return nil, "", fmt.Errorf("error reading panel render: %v", err)
+	}
+	rc := struct {
+		io.Reader
+		io.Closer
+	}{r, body}
+	return rc, detectFormat(head), nil
+}
+
+func detectFormat(head []byte) ImageFormat {
+	switch {
+	case bytes.HasPrefix(head, []byte("%PDF")):
+		return PDF
+	case bytes.Contains(head, []byte("<svg")):
+		return SVG
+	default:
+		return PNG
+	}
 }
 
 var getPanelRetrySleepTime = time.Duration(10) * time.Second
@@ -51,6 +128,26 @@ var getPanelRetrySleepTime = time.Duration(10) * time.Second
 // authorization headers will be omitted from requests.
 // variables are Grafana template variable url values of the form var-{name}={value}, e.g. var-host=dev
 func NewV4Client(grafanaURL string, apiToken string, variables url.Values, sslCheck bool, gridLayout bool) Client {
+	opts := ClientOptions{InsecureSkipVerify: !sslCheck}
+	c, err := NewV4ClientWithOptions(grafanaURL, apiToken, variables, gridLayout, opts)
+	if err != nil {
+		//only options reading files or parsing a proxy url fail, and opts has none
+		logger().Error("creating the HTTP client failed, using the default one", "err", err)
+		return newV4Client(grafanaURL, variables, gridLayout, http.DefaultClient, opts.authenticator(apiToken), opts)
+	}
+	return c
+}
+
+// NewV4ClientWithOptions creates a new Grafana 4 Client connecting to Grafana as configured by opts.
+func NewV4ClientWithOptions(grafanaURL string, apiToken string, variables url.Values, gridLayout bool, opts ClientOptions) (Client, error) {
+	httpClient, err := opts.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	return newV4Client(grafanaURL, variables, gridLayout, httpClient, opts.authenticator(apiToken), opts), nil
+}
+
+func newV4Client(grafanaURL string, variables url.Values, gridLayout bool, httpClient *http.Client, auth Authenticator, opts ClientOptions) client {
 	getDashEndpoint := func(dashName string) string {
 		dashURL := grafanaURL + "/api/dashboards/db/" + dashName
 		if len(variables) > 0 {
@@ -62,13 +159,34 @@ func NewV4Client(grafanaURL string, apiToken string, variables url.Values, sslCh
 	getPanelEndpoint := func(dashName string, vals url.Values) string {
 		return fmt.Sprintf("%s/render/dashboard-solo/db/%s?%s", grafanaURL, dashName, vals.Encode())
 	}
-	return client{grafanaURL, getDashEndpoint, getPanelEndpoint, apiToken, variables, sslCheck, gridLayout}
+	//Grafana 4 only lists versions by dashboard id, dashboardVersion falls back to fetching the dashboard
+	return client{grafanaURL, getDashEndpoint, nil, getPanelEndpoint, auth, variables, httpClient, gridLayout, nil, true, opts.Scale, false, &formatSupport{}}
 }
 
 // NewV5Client creates a new Grafana 5 Client. If apiToken is the empty string,
 // authorization headers will be omitted from requests.
 // variables are Grafana template variable url values of the form var-{name}={value}, e.g. var-host=dev
 func NewV5Client(grafanaURL string, apiToken string, variables url.Values, sslCheck bool, gridLayout bool) Client {
+	opts := ClientOptions{InsecureSkipVerify: !sslCheck}
+	c, err := NewV5ClientWithOptions(grafanaURL, apiToken, variables, gridLayout, opts)
+	if err != nil {
+		//only options reading files or parsing a proxy url fail, and opts has none
+		logger().Error("creating the HTTP client failed, using the default one", "err", err)
+		return newV5Client(grafanaURL, variables, gridLayout, http.DefaultClient, opts.authenticator(apiToken), opts)
+	}
+	return c
+}
+
+// NewV5ClientWithOptions creates a new Grafana 5 Client connecting to Grafana as configured by opts.
+func NewV5ClientWithOptions(grafanaURL string, apiToken string, variables url.Values, gridLayout bool, opts ClientOptions) (Client, error) {
+	httpClient, err := opts.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	return newV5Client(grafanaURL, variables, gridLayout, httpClient, opts.authenticator(apiToken), opts), nil
+}
+
+func newV5Client(grafanaURL string, variables url.Values, gridLayout bool, httpClient *http.Client, auth Authenticator, opts ClientOptions) client {
 	getDashEndpoint := func(dashName string) string {
 		dashURL := grafanaURL + "/api/dashboards/uid/" + dashName
 		if len(variables) > 0 {
@@ -77,123 +195,306 @@ func NewV5Client(grafanaURL string, apiToken string, variables url.Values, sslCh
 		return dashURL
 	}
 
+	getDashVersionEndpoint := func(dashName string) string {
+		return grafanaURL + "/api/dashboards/uid/" + dashName + "/versions?limit=1"
+	}
+
 	getPanelEndpoint := func(dashName string, vals url.Values) string {
 		return fmt.Sprintf("%s/render/d-solo/%s/_?%s", grafanaURL, dashName, vals.Encode())
 	}
-	return client{grafanaURL, getDashEndpoint, getPanelEndpoint, apiToken, variables, sslCheck, gridLayout}
+	return client{grafanaURL, getDashEndpoint, getDashVersionEndpoint, getPanelEndpoint, auth, variables, httpClient, gridLayout, nil, false, opts.Scale, false, &formatSupport{}}
+}
+
+// newModernClient creates a client for Grafana 7 and later, which render panels
+// in the organisation given by orgId and at the device scale factor given by scale
+func newModernClient(grafanaURL string, variables url.Values, gridLayout bool, httpClient *http.Client, auth Authenticator, opts ClientOptions) client {
+	g := newV5Client(grafanaURL, variables, gridLayout, httpClient, auth, opts)
+	g.renderValues = url.Values{}
+	if opts.OrgID > 0 {
+		g.renderValues.Set("orgId", strconv.Itoa(opts.OrgID))
+	}
+	g.scaleParam = true
+	return g
 }
 
-func (g client) GetDashboard(dashName string) (Dashboard, error) {
+func (g client) GetDashboard(ctx context.Context, dashName string) (dash Dashboard, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "grafana.GetDashboard", trace.WithAttributes(attribute.String("dashboard", dashName)))
+	defer func() { tracing.End(span, err) }()
+
 	dashURL := g.getDashEndpoint(dashName)
-	log.Println("Connecting to dashboard at", dashURL)
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: !g.sslCheck},
+	logger().Debug("fetching dashboard", "dashboard", dashName, "url", redactURL(dashURL))
+	body, err := g.get(ctx, "getDashboard", dashURL)
+	if err != nil {
+		return Dashboard{}, err
+	}
+	return NewDashboard(body, g.variables), nil
+}
+
+// dashboardVersion returns the current version of the dashboard without downloading its definition.
+// Grafana only lists the versions of a dashboard to users who can save it; for others, e.g. Viewer tokens,
+// the error wraps errVersionUnsupported.
+func (g client) dashboardVersion(ctx context.Context, dashName string) (v int, err error) {
+	if g.getDashVersionEndpoint == nil {
+		dash, err := g.GetDashboard(ctx, dashName)
+		return dash.Version, err
+	}
+	ctx, span := tracing.Tracer().Start(ctx, "grafana.GetDashboardVersion", trace.WithAttributes(attribute.String("dashboard", dashName)))
+	defer func() { tracing.End(span, err) }()
+
+	versionsURL := g.getDashVersionEndpoint(dashName)
+	body, err := g.get(ctx, "getDashboardVersion", versionsURL)
+	var status *statusError
+	if errors.As(err, &status) && (status.code == http.StatusForbidden || status.code == http.StatusNotFound) {
+		return 0, fmt.Errorf("%w: %v", errVersionUnsupported, err)
 	}
-	client := &http.Client{Transport: tr}
-	req, err := http.NewRequest("GET", dashURL, nil)
 	if err != nil {
-		return Dashboard{}, fmt.Errorf("error creating getDashboard request for %v: %v", dashURL, err)
+		return 0, err
 	}
 
-	if g.apiToken != "" {
-		req.Header.Add("Authorization", "Bearer "+g.apiToken)
+	type version struct {
+		Version int
 	}
-	resp, err := client.Do(req)
+	//Grafana 11 wraps the list of versions in an object
+	var versions []version
+	var wrapped struct {
+		Versions []version
+	}
+	if err = json.Unmarshal(body, &versions); err != nil {
+		if err = json.Unmarshal(body, &wrapped); err != nil {
+			return 0, fmt.Errorf("error parsing getDashboardVersion response from %v: %v", redactURL(versionsURL), err)
+		}
+		versions = wrapped.Versions
+	}
+	if len(versions) == 0 {
+		return 0, fmt.Errorf("error obtaining dashboard version from %v: no versions found", redactURL(versionsURL))
+	}
+	return versions[0].Version, nil
+}
+
+func (g client) get(ctx context.Context, op string, reqURL string) ([]byte, error) {
+	return g.do(ctx, op, "GET", reqURL, nil)
+}
+
+// do executes a request with an optional JSON body, and returns the response body if the request succeeded
+func (g client) do(ctx context.Context, op string, method string, reqURL string, reqBody []byte) ([]byte, error) {
+	var bodyReader io.Reader
+	if reqBody != nil {
+		bodyReader = bytes.NewReader(reqBody)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, bodyReader)
+	if err != nil {
+		return nil, fmt.Errorf("error creating %s request for %v: %v", op, redactURL(reqURL), err)
+	}
+	if reqBody != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	tracing.Inject(ctx, req.Header)
+
+	if err = g.auth.Authenticate(req); err != nil {
+		return nil, fmt.Errorf("error authenticating %s request: %v", op, err)
+	}
+	resp, err := g.httpClient.Do(req)
 	if err != nil {
-		return Dashboard{}, fmt.Errorf("error executing getDashboard request for %v: %v", dashURL, err)
+		return nil, fmt.Errorf("error executing %s request for %v: %v", op, redactURL(reqURL), redactErr(err))
 	}
 	defer resp.Body.Close()
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return Dashboard{}, fmt.Errorf("error reading getDashboard response body from %v: %v", dashURL, err)
+		return nil, fmt.Errorf("error reading %s response body from %v: %v", op, redactURL(reqURL), err)
 	}
 
 	if resp.StatusCode != 200 {
-		return Dashboard{}, fmt.Errorf("error obtaining dashboard from %v. Got Status %v, message: %v ", dashURL, resp.Status, string(body))
+		return nil, &statusError{fmt.Sprintf("error executing %s request for %v. Got Status %v, message: %v ", op, redactURL(reqURL), resp.Status, string(body)), resp.StatusCode}
 	}
+	return body, nil
+}
 
-	return NewDashboard(body, g.variables), nil
+// statusError is the error of a request answered with a status other than 200 OK
+type statusError struct {
+	msg  string
+	code int
+}
+
+func (e *statusError) Error() string {
+	return e.msg
 }
 
-func (g client) GetPanelPng(p Panel, dashName string, t TimeRange) (io.ReadCloser, error) {
-	panelURL := g.getPanelURL(p, dashName, t)
+func (g client) RenderPanel(ctx context.Context, p Panel, dashName string, t TimeRange, opts RenderOptions) (body io.ReadCloser, format ImageFormat, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "grafana.RenderPanel", trace.WithAttributes(attribute.String("dashboard", dashName), attribute.Int("panel", p.Id)))
+	defer func() {
+		span.SetAttributes(attribute.String("format", string(format)))
+		tracing.End(span, err)
+	}()
+	defer prometheus.NewTimer(metrics.PanelRenderDuration).ObserveDuration()
 
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: !g.sslCheck},
+	//vector formats are tried once, as renderers that do not support them fail consistently,
+	//and not again once the renderer has failed them
+	for _, f := range opts.formats() {
+		if f == PNG {
+			break
+		}
+		if !g.formats.supported(f) {
+			continue
+		}
+		body, err = g.getPanel(ctx, span, g.getPanelURL(p, dashName, t, opts, f), dashName, p, 1)
+		if err != nil {
+			logger().Debug("vector panel render failed, falling back", "dashboard", dashName, "panel", p.Id, "format", f, "err", err)
+			var status *statusError
+			if errors.As(err, &status) {
+				g.formats.setUnsupported(f)
+			}
+			continue
+		}
+		return sniffFormat(body)
+	}
+	body, err = g.getPanel(ctx, span, g.getPanelURL(p, dashName, t, opts, PNG), dashName, p, 3)
+	if err != nil {
+		return nil, "", err
 	}
-	client := &http.Client{
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			return errors.New("Error getting panel png. Redirected to login")
-		},
-		Transport: tr,
+	return body, PNG, nil
+}
+
+// getPanel requests a panel render, making at most attempts requests while Grafana does not respond with a render
+func (g client) getPanel(ctx context.Context, span trace.Span, panelURL string, dashName string, p Panel, attempts int) (io.ReadCloser, error) {
+	//a copy shares the connection pool of the transport
+	client := *g.httpClient
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return errors.New("Error getting panel png. Redirected to login")
 	}
-	req, err := http.NewRequest("GET", panelURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", panelURL, nil)
 	if err != nil {
-		return nil, fmt.Errorf("error creating getPanelPng request for %v: %v", panelURL, err)
+		return nil, fmt.Errorf("error creating getPanelPng request for %v: %v", redactURL(panelURL), err)
 	}
-	if g.apiToken != "" {
-		req.Header.Add("Authorization", "Bearer "+g.apiToken)
+	tracing.Inject(ctx, req.Header)
+	if err = g.auth.Authenticate(req); err != nil {
+		return nil, fmt.Errorf("error authenticating getPanelPng request: %v", err)
 	}
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("error executing getPanelPng request for %v: %v", panelURL, err)
+		return nil, fmt.Errorf("error executing getPanelPng request for %v: %v", redactURL(panelURL), redactErr(err))
 	}
 
-	for retries := 1; retries < 3 && resp.StatusCode != 200; retries++ {
+	for retries := 1; retries < attempts && resp.StatusCode != 200; retries++ {
 		delay := getPanelRetrySleepTime * time.Duration(retries)
-		log.Printf("Error obtaining render for panel %+v, Status: %v, Retrying after %v...", p, resp.StatusCode, delay)
-		time.Sleep(delay)
+		logger().Warn("panel render failed, retrying", "dashboard", dashName, "panel", p.Id, "status", resp.StatusCode, "attempt", retries, "delay", delay)
+		metrics.PanelRenderRetries.Inc()
+		span.AddEvent("retry", trace.WithAttributes(attribute.Int("attempt", retries), attribute.Int("status", resp.StatusCode)))
+		resp.Body.Close()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
 		resp, err = client.Do(req)
 		if err != nil {
-			return nil, fmt.Errorf("error executing retry getPanelPng request for %v: %v", panelURL, err)
+			return nil, fmt.Errorf("error executing retry getPanelPng request for %v: %v", redactURL(panelURL), redactErr(err))
 		}
 	}
 
 	if resp.StatusCode != 200 {
+		defer resp.Body.Close()
 		body, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
 			panic(err)
 		}
-		log.Println("Error obtaining render:", string(body))
-		return nil, errors.New("Error obtaining render: " + resp.Status)
+		logger().Error("panel render failed", "dashboard", dashName, "panel", p.Id, "status", resp.StatusCode, "body", string(body))
+		return nil, &statusError{"Error obtaining render: " + resp.Status, resp.StatusCode}
 	}
 
 	return resp.Body, nil
 }
 
-func (g client) getPanelURL(p Panel, dashName string, t TimeRange) string {
+// formatSupport remembers the vector formats the renderer of a client failed. Copies of the client share it.
+type formatSupport struct {
+	mu          sync.Mutex
+	unsupported map[ImageFormat]bool
+}
+
+// supported reports whether f has not failed yet. Clients without a formatSupport try every format.
+func (s *formatSupport) supported(f ImageFormat) bool {
+	if s == nil {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.unsupported[f]
+}
+
+func (s *formatSupport) setUnsupported(f ImageFormat) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.unsupported[f] {
+		return
+	}
+	if s.unsupported == nil {
+		s.unsupported = map[ImageFormat]bool{}
+	}
+	s.unsupported[f] = true
+	logger().Info("the renderer does not support a vector format, rendering PNG instead", "format", f)
+}
+
+func (g client) getPanelURL(p Panel, dashName string, t TimeRange, opts RenderOptions, format ImageFormat) string {
+	values := g.panelValues(p, t, opts)
+	if format != PNG {
+		values.Set("encoding", string(format))
+	}
+	url := g.getPanelEndpoint(dashName, values)
+	logger().Debug("rendering panel", "dashboard", dashName, "panel", p.Id, "url", redactURL(url))
+	return url
+}
+
+// dashboardURL returns the url the dashboard definition is fetched from
+func (g client) dashboardURL(dashName string) string {
+	return g.getDashEndpoint(dashName)
+}
+
+// panelValues returns the url values used to request a render of the panel
+func (g client) panelValues(p Panel, t TimeRange, opts RenderOptions) url.Values {
+	theme := opts.Theme
+	if theme == "" {
+		theme = LightTheme
+	}
 	values := url.Values{}
-	values.Add("theme", "light")
+	values.Add("theme", theme)
 	values.Add("panelId", strconv.Itoa(p.Id))
 	values.Add("from", t.From)
 	values.Add("to", t.To)
 
+	var width, height float64
 	if g.gridLayout {
-		width := int(p.GridPos.W * 40)
-		height := int(p.GridPos.H * 40)
-		values.Add("width", strconv.Itoa(width))
-		values.Add("height", strconv.Itoa(height))
+		width = p.GridPos.W * 40
+		height = p.GridPos.H * 40
 	} else {
-		if p.Is(SingleStat) {
-			values.Add("width", "300")
-			values.Add("height", "150")
+		if p.IsSingleStat() {
+			width, height = 300, 150
 		} else if p.Is(Text) {
-			values.Add("width", "1000")
-			values.Add("height", "100")
+			width, height = 1000, 100
 		} else {
-			values.Add("width", "1000")
-			values.Add("height", "500")
+			width, height = 1000, 500
 		}
 	}
+	//Grafana 7 and later scale the rendered image. Older versions are asked for a larger panel, which is laid out
+	//for the larger size, so its text and lines shrink when the report fits it to the page
+	if g.scale > 0 && g.scaleParam {
+		values.Add("scale", strconv.FormatFloat(g.scale, 'f', -1, 64))
+	} else if g.scale > 0 {
+		width *= g.scale
+		height *= g.scale
+	}
+	values.Add("width", strconv.Itoa(int(width)))
+	values.Add("height", strconv.Itoa(int(height)))
 
 	for k, v := range g.variables {
 		for _, singleValue := range v {
 			values.Add(k, singleValue)
 		}
 	}
-
-	url := g.getPanelEndpoint(dashName, values)
-	log.Println("Downloading image ", p.Id, url)
-	return url
+	for k, v := range g.renderValues {
+		values[k] = v
+	}
+	return values
 }
@@ -17,14 +17,23 @@
 package grafana
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"testing"
 	"time"
 
+	"github.com/mlesar/grafana-report/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/smartystreets/goconvey/convey"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 func TestGrafanaClientFetchesDashboard(t *testing.T) {
@@ -38,7 +47,7 @@ func TestGrafanaClientFetchesDashboard(t *testing.T) {
 
 		c.Convey("When using the Grafana v4 client", func(c convey.C) {
 			grf := NewV4Client(ts.URL, "", url.Values{}, true, false)
-			grf.GetDashboard("testDash")
+			grf.GetDashboard(context.Background(), "testDash")
 
 			c.Convey("It should use the v4 dashboards endpoint", func(c convey.C) {
 				c.So(requestURI, convey.ShouldEqual, "/api/dashboards/db/testDash")
@@ -47,7 +56,7 @@ func TestGrafanaClientFetchesDashboard(t *testing.T) {
 
 		c.Convey("When using the Grafana v5 client", func(c convey.C) {
 			grf := NewV5Client(ts.URL, "", url.Values{}, true, false)
-			grf.GetDashboard("rYy7Paekz")
+			grf.GetDashboard(context.Background(), "rYy7Paekz")
 
 			c.Convey("It should use the v5 dashboards endpoint", func(c convey.C) {
 				c.So(requestURI, convey.ShouldEqual, "/api/dashboards/uid/rYy7Paekz")
@@ -57,6 +66,49 @@ func TestGrafanaClientFetchesDashboard(t *testing.T) {
 	})
 }
 
+func TestGrafanaClientFetchesDashboardVersion(t *testing.T) {
+	convey.Convey("When fetching a Dashboard version", t, func(c convey.C) {
+		requestURI := ""
+		response := ""
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestURI = r.RequestURI
+			fmt.Fprintln(w, response)
+		}))
+		defer ts.Close()
+
+		c.Convey("When using the Grafana v4 client", func(c convey.C) {
+			response = `{"Dashboard":{"Version":4}}`
+			grf := NewV4Client(ts.URL, "", url.Values{}, true, false)
+			version, err := grf.(client).dashboardVersion(context.Background(), "testDash")
+
+			c.Convey("It should fetch the dashboard", func(c convey.C) {
+				c.So(err, convey.ShouldBeNil)
+				c.So(requestURI, convey.ShouldEqual, "/api/dashboards/db/testDash")
+				c.So(version, convey.ShouldEqual, 4)
+			})
+		})
+
+		c.Convey("When using the Grafana v5 client", func(c convey.C) {
+			response = `[{"id":8,"version":5}]`
+			grf := NewV5Client(ts.URL, "", url.Values{}, true, false)
+			version, err := grf.(client).dashboardVersion(context.Background(), "rYy7Paekz")
+
+			c.Convey("It should use the v5 dashboard versions endpoint", func(c convey.C) {
+				c.So(err, convey.ShouldBeNil)
+				c.So(requestURI, convey.ShouldEqual, "/api/dashboards/uid/rYy7Paekz/versions?limit=1")
+				c.So(version, convey.ShouldEqual, 5)
+			})
+
+			c.Convey("It should understand the Grafana 11 response", func(c convey.C) {
+				response = `{"continueToken":"","versions":[{"id":8,"version":6}]}`
+				version, err := grf.(client).dashboardVersion(context.Background(), "rYy7Paekz")
+				c.So(err, convey.ShouldBeNil)
+				c.So(version, convey.ShouldEqual, 6)
+			})
+		})
+	})
+}
+
 func TestGrafanaClientFetchesPanelPNG(t *testing.T) {
 	convey.Convey("When fetching a panel PNG", t, func(c convey.C) {
 		requestURI := ""
@@ -82,7 +134,7 @@ func TestGrafanaClientFetchesPanelPNG(t *testing.T) {
 		}
 		for clientDesc, cl := range cases {
 			grf := cl.client
-			grf.GetPanelPng(Panel{44, "singlestat", "title", GridPos{0, 0, 0, 0}}, "testDash", TimeRange{"now-1h", "now"})
+			grf.RenderPanel(context.Background(), Panel{Id: 44, Type: "singlestat", Title: "title", GridPos: GridPos{0, 0, 0, 0}}, "testDash", TimeRange{"now-1h", "now"}, RenderOptions{})
 
 			c.Convey(fmt.Sprintf("The %s client should use the render endpoint with the dashboard name", clientDesc), func(c convey.C) {
 				c.So(requestURI, convey.ShouldStartWith, cl.pngEndpoint)
@@ -112,13 +164,13 @@ func TestGrafanaClientFetchesPanelPNG(t *testing.T) {
 			})
 
 			c.Convey(fmt.Sprintf("The %s client should request text panels with a small height", clientDesc), func(c convey.C) {
-				grf.GetPanelPng(Panel{44, "text", "title", GridPos{0, 0, 0, 0}}, "testDash", TimeRange{"now", "now-1h"})
+				grf.RenderPanel(context.Background(), Panel{Id: 44, Type: "text", Title: "title", GridPos: GridPos{0, 0, 0, 0}}, "testDash", TimeRange{"now", "now-1h"}, RenderOptions{})
 				c.So(requestURI, convey.ShouldContainSubstring, "width=1000")
 				c.So(requestURI, convey.ShouldContainSubstring, "height=100")
 			})
 
 			c.Convey(fmt.Sprintf("The %s client should request other panels in a larger size", clientDesc), func(c convey.C) {
-				grf.GetPanelPng(Panel{44, "graph", "title", GridPos{0, 0, 0, 0}}, "testDash", TimeRange{"now", "now-1h"})
+				grf.RenderPanel(context.Background(), Panel{Id: 44, Type: "graph", Title: "title", GridPos: GridPos{0, 0, 0, 0}}, "testDash", TimeRange{"now", "now-1h"}, RenderOptions{})
 				c.So(requestURI, convey.ShouldContainSubstring, "width=1000")
 				c.So(requestURI, convey.ShouldContainSubstring, "height=500")
 			})
@@ -135,13 +187,13 @@ func TestGrafanaClientFetchesPanelPNG(t *testing.T) {
 			grf := cl.client
 
 			c.Convey(fmt.Sprintf("The %s client should request grid layout panels with width=1000 and height=240", clientDesc), func(c convey.C) {
-				grf.GetPanelPng(Panel{44, "graph", "title", GridPos{6, 24, 0, 0}}, "testDash", TimeRange{"now", "now-1h"})
+				grf.RenderPanel(context.Background(), Panel{Id: 44, Type: "graph", Title: "title", GridPos: GridPos{6, 24, 0, 0}}, "testDash", TimeRange{"now", "now-1h"}, RenderOptions{})
 				c.So(requestURI, convey.ShouldContainSubstring, "width=960")
 				c.So(requestURI, convey.ShouldContainSubstring, "height=240")
 			})
 
 			c.Convey(fmt.Sprintf("The %s client should request grid layout panels with width=480 and height=120", clientDesc), func(c convey.C) {
-				grf.GetPanelPng(Panel{44, "graph", "title", GridPos{3, 12, 0, 0}}, "testDash", TimeRange{"now", "now-1h"})
+				grf.RenderPanel(context.Background(), Panel{Id: 44, Type: "graph", Title: "title", GridPos: GridPos{3, 12, 0, 0}}, "testDash", TimeRange{"now", "now-1h"}, RenderOptions{})
 				c.So(requestURI, convey.ShouldContainSubstring, "width=480")
 				c.So(requestURI, convey.ShouldContainSubstring, "height=120")
 			})
@@ -154,6 +206,107 @@ func init() {
 	getPanelRetrySleepTime = time.Duration(1) * time.Millisecond //we want our tests to run fast
 }
 
+func TestGrafanaClientRenderOptions(t *testing.T) {
+	convey.Convey("When rendering panels with options", t, func(c convey.C) {
+		requestURI := ""
+		requestHeaders := http.Header{}
+		ts := versionServer("10.4.1", false, &requestURI, &requestHeaders)
+		defer ts.Close()
+		panel := Panel{Id: 44, Type: "graph", Title: "title", GridPos: GridPos{6, 24, 0, 0}}
+
+		c.Convey("Grafana 7 and later should be passed the scale at the default pixel dimensions", func(c convey.C) {
+			grf, _ := NewClient(context.Background(), ts.URL, "", url.Values{}, false, ClientOptions{Scale: PrintScale})
+			grf.RenderPanel(context.Background(), panel, "testDash", TimeRange{"now-1h", "now"}, RenderOptions{})
+			c.So(requestURI, convey.ShouldContainSubstring, "scale=2")
+			c.So(requestURI, convey.ShouldContainSubstring, "width=1000")
+			c.So(requestURI, convey.ShouldContainSubstring, "height=500")
+		})
+
+		c.Convey("Older versions should be requested to render scaled pixel dimensions", func(c convey.C) {
+			grf, _ := NewV5ClientWithOptions(ts.URL, "", url.Values{}, true, ClientOptions{Scale: 1.5})
+			grf.RenderPanel(context.Background(), panel, "testDash", TimeRange{"now-1h", "now"}, RenderOptions{})
+			c.So(requestURI, convey.ShouldNotContainSubstring, "scale=")
+			c.So(requestURI, convey.ShouldContainSubstring, "width=1440")
+			c.So(requestURI, convey.ShouldContainSubstring, "height=360")
+		})
+
+		c.Convey("Panels should be rendered in the requested theme", func(c convey.C) {
+			grf := NewV5Client(ts.URL, "", url.Values{}, true, false)
+			grf.RenderPanel(context.Background(), panel, "testDash", TimeRange{"now-1h", "now"}, RenderOptions{})
+			c.So(requestURI, convey.ShouldContainSubstring, "theme=light")
+			grf.RenderPanel(context.Background(), panel, "testDash", TimeRange{"now-1h", "now"}, RenderOptions{Theme: DarkTheme})
+			c.So(requestURI, convey.ShouldContainSubstring, "theme=dark")
+		})
+
+		c.Convey("The scale for a DPI should be relative to the screen resolution", func(c convey.C) {
+			c.So(ScaleForDPI(300), convey.ShouldEqual, PrintScale)
+			c.So(ScaleForDPI(150), convey.ShouldEqual, ScreenScale)
+		})
+	})
+}
+
+func TestGrafanaClientRenderFormats(t *testing.T) {
+	convey.Convey("When rendering panels in vector formats", t, func(c convey.C) {
+		var encodings []string
+		supported := map[string]string{"pdf": "%PDF-1.7\n", "svg": `<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg"></svg>`}
+		failUnsupported := false
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encoding := r.URL.Query().Get("encoding")
+			encodings = append(encodings, encoding)
+			if body, ok := supported[encoding]; ok {
+				fmt.Fprint(w, body)
+				return
+			}
+			if encoding != "" && failUnsupported {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(w, "\x89PNG")
+		}))
+		defer ts.Close()
+		grf := NewV5Client(ts.URL, "", url.Values{}, true, false)
+		panel := Panel{Id: 44, Type: "graph"}
+
+		c.Convey("It should render the first supported format", func(c convey.C) {
+			body, format, err := grf.RenderPanel(context.Background(), panel, "testDash", TimeRange{"now-1h", "now"}, RenderOptions{Formats: []ImageFormat{SVG, PDF}})
+			c.So(err, convey.ShouldBeNil)
+			c.So(format, convey.ShouldEqual, SVG)
+			image, _ := ioutil.ReadAll(body)
+			c.So(string(image), convey.ShouldEqual, supported["svg"])
+			c.So(encodings, convey.ShouldResemble, []string{"svg"})
+		})
+
+		c.Convey("It should detect renderers ignoring the encoding", func(c convey.C) {
+			_, format, err := grf.RenderPanel(context.Background(), panel, "testDash", TimeRange{"now-1h", "now"}, RenderOptions{Formats: []ImageFormat{"eps"}})
+			c.So(err, convey.ShouldBeNil)
+			c.So(format, convey.ShouldEqual, PNG)
+			c.So(encodings, convey.ShouldResemble, []string{"eps"})
+		})
+
+		c.Convey("It should fall back to PNG without retrying failed vector renders", func(c convey.C) {
+			failUnsupported = true
+			_, format, err := grf.RenderPanel(context.Background(), panel, "testDash", TimeRange{"now-1h", "now"}, RenderOptions{Formats: []ImageFormat{"eps"}})
+			c.So(err, convey.ShouldBeNil)
+			c.So(format, convey.ShouldEqual, PNG)
+			c.So(encodings, convey.ShouldResemble, []string{"eps", ""})
+
+			c.Convey("It should not request the failed format again", func(c convey.C) {
+				_, format, err := grf.RenderPanel(context.Background(), panel, "testDash", TimeRange{"now-1h", "now"}, RenderOptions{Formats: []ImageFormat{"eps", PDF}})
+				c.So(err, convey.ShouldBeNil)
+				c.So(format, convey.ShouldEqual, PDF)
+				c.So(encodings, convey.ShouldResemble, []string{"eps", "", "pdf"})
+			})
+		})
+
+		c.Convey("It should render PNG by default", func(c convey.C) {
+			_, format, err := grf.RenderPanel(context.Background(), panel, "testDash", TimeRange{"now-1h", "now"}, RenderOptions{})
+			c.So(err, convey.ShouldBeNil)
+			c.So(format, convey.ShouldEqual, PNG)
+			c.So(encodings, convey.ShouldResemble, []string{""})
+		})
+	})
+}
+
 func TestGrafanaClientFetchPanelPNGErrorHandling(t *testing.T) {
 	convey.Convey("When trying to fetching a panel from the server sometimes returns an error", t, func(c convey.C) {
 		try := 0
@@ -169,11 +322,16 @@ func TestGrafanaClientFetchPanelPNGErrorHandling(t *testing.T) {
 
 		grf := NewV4Client(ts.URL, "", url.Values{}, true, false)
 
-		_, err := grf.GetPanelPng(Panel{44, "singlestat", "title", GridPos{0, 0, 0, 0}}, "testDash", TimeRange{"now-1h", "now"})
+		retries := testutil.ToFloat64(metrics.PanelRenderRetries)
+		_, _, err := grf.RenderPanel(context.Background(), Panel{Id: 44, Type: "singlestat", Title: "title", GridPos: GridPos{0, 0, 0, 0}}, "testDash", TimeRange{"now-1h", "now"}, RenderOptions{})
 
 		c.Convey("It should retry a couple of times if it receives errors", func(c convey.C) {
 			c.So(err, convey.ShouldBeNil)
 		})
+
+		c.Convey("It should count the retries", func(c convey.C) {
+			c.So(testutil.ToFloat64(metrics.PanelRenderRetries)-retries, convey.ShouldEqual, 1)
+		})
 	})
 
 	convey.Convey("When trying to fetching a panel from the server consistently returns an error", t, func(c convey.C) {
@@ -184,10 +342,57 @@ func TestGrafanaClientFetchPanelPNGErrorHandling(t *testing.T) {
 
 		grf := NewV4Client(ts.URL, "", url.Values{}, true, false)
 
-		_, err := grf.GetPanelPng(Panel{44, "singlestat", "title", GridPos{0, 0, 0, 0}}, "testDash", TimeRange{"now-1h", "now"})
+		_, _, err := grf.RenderPanel(context.Background(), Panel{Id: 44, Type: "singlestat", Title: "title", GridPos: GridPos{0, 0, 0, 0}}, "testDash", TimeRange{"now-1h", "now"}, RenderOptions{})
 
 		c.Convey("The Grafana API should return an error", func(c convey.C) {
 			c.So(err, convey.ShouldNotBeNil)
 		})
+
+		c.Convey("Cancelling the context should stop waiting to retry", func(c convey.C) {
+			getPanelRetrySleepTime = time.Hour
+			defer func() { getPanelRetrySleepTime = time.Millisecond }()
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			_, _, err := grf.RenderPanel(ctx, Panel{Id: 44, Type: "singlestat", Title: "title", GridPos: GridPos{0, 0, 0, 0}}, "testDash", TimeRange{"now-1h", "now"}, RenderOptions{})
+			c.So(errors.Is(err, context.DeadlineExceeded), convey.ShouldBeTrue)
+		})
+	})
+}
+
+func TestGrafanaClientTracing(t *testing.T) {
+	convey.Convey("When fetching a panel PNG with tracing enabled", t, func(c convey.C) {
+		exporter := tracetest.NewInMemoryExporter()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+		defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+		try := 0
+		traceparent := ""
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			traceparent = r.Header.Get("traceparent")
+			if try < 1 {
+				w.WriteHeader(http.StatusInternalServerError)
+				try++
+			}
+		}))
+		defer ts.Close()
+
+		grf := NewV5Client(ts.URL, "", url.Values{}, true, false)
+		_, _, err := grf.RenderPanel(context.Background(), Panel{Id: 44, Type: "graph"}, "testDash", TimeRange{"now-1h", "now"}, RenderOptions{})
+		c.So(err, convey.ShouldBeNil)
+		spans := exporter.GetSpans()
+
+		c.Convey("It should record a span for the panel render", func(c convey.C) {
+			c.So(spans, convey.ShouldHaveLength, 1)
+			c.So(spans[0].Name, convey.ShouldEqual, "grafana.RenderPanel")
+		})
+
+		c.Convey("It should record retries as events", func(c convey.C) {
+			c.So(spans[0].Events, convey.ShouldHaveLength, 1)
+			c.So(spans[0].Events[0].Name, convey.ShouldEqual, "retry")
+		})
+
+		c.Convey("It should propagate the trace context to Grafana", func(c convey.C) {
+			c.So(traceparent, convey.ShouldContainSubstring, spans[0].SpanContext.TraceID().String())
+		})
 	})
 }
@@ -0,0 +1,97 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// Authenticator adds credentials to the dashboard and render requests sent to Grafana
+type Authenticator interface {
+	Authenticate(req *http.Request) error
+}
+
+// AuthenticatorFunc adapts a function to the Authenticator interface
+type AuthenticatorFunc func(req *http.Request) error
+
+func (f AuthenticatorFunc) Authenticate(req *http.Request) error {
+	return f(req)
+}
+
+// BearerToken authenticates with a Grafana API key or service account token
+func BearerToken(token string) Authenticator {
+	return Header("Authorization", "Bearer "+token)
+}
+
+// BasicAuth authenticates with a Grafana user name and password
+func BasicAuth(user, password string) Authenticator {
+	return AuthenticatorFunc(func(req *http.Request) error {
+		req.SetBasicAuth(user, password)
+		return nil
+	})
+}
+
+// SessionCookie forwards the session cookie of a logged in Grafana user.
+// name is Grafana's login_cookie_name, grafana_session by default.
+func SessionCookie(name, value string) Authenticator {
+	return AuthenticatorFunc(func(req *http.Request) error {
+		req.AddCookie(&http.Cookie{Name: name, Value: value})
+		return nil
+	})
+}
+
+// OrgID selects the Grafana organisation of the dashboard, for users belonging to several organisations
+func OrgID(id int) Authenticator {
+	return Header("X-Grafana-Org-Id", strconv.Itoa(id))
+}
+
+// AuthProxy authenticates as user through Grafana's auth proxy, with the default X-WEBAUTH-USER header.
+// Use Header() if Grafana is configured with another header_name.
+func AuthProxy(user string) Authenticator {
+	return Header("X-WEBAUTH-USER", user)
+}
+
+// JWT passes a JSON Web Token through to Grafana, with the default X-JWT-Assertion header.
+// Use Header() if Grafana is configured with another header_name.
+func JWT(token string) Authenticator {
+	return Header("X-JWT-Assertion", token)
+}
+
+// Header sets a request header, e.g. for auth proxy or JWT setups with custom header names
+func Header(name, value string) Authenticator {
+	return AuthenticatorFunc(func(req *http.Request) error {
+		req.Header.Set(name, value)
+		return nil
+	})
+}
+
+// Combine applies all authenticators in order, e.g. BasicAuth and OrgID.
+// nil authenticators are skipped.
+func Combine(auths ...Authenticator) Authenticator {
+	return AuthenticatorFunc(func(req *http.Request) error {
+		for _, a := range auths {
+			if a == nil {
+				continue
+			}
+			if err := a.Authenticate(req); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,85 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestAuthenticators(t *testing.T) {
+	convey.Convey("When authenticating a request", t, func(c convey.C) {
+		req := httptest.NewRequest("GET", "http://grafana/api/dashboards/uid/testDash", nil)
+
+		c.Convey("BasicAuth should set the user and password", func(c convey.C) {
+			c.So(BasicAuth("admin", "secret").Authenticate(req), convey.ShouldBeNil)
+			user, password, ok := req.BasicAuth()
+			c.So(ok, convey.ShouldBeTrue)
+			c.So(user, convey.ShouldEqual, "admin")
+			c.So(password, convey.ShouldEqual, "secret")
+		})
+
+		c.Convey("SessionCookie should forward the cookie", func(c convey.C) {
+			c.So(SessionCookie("grafana_session", "abcd").Authenticate(req), convey.ShouldBeNil)
+			cookie, err := req.Cookie("grafana_session")
+			c.So(err, convey.ShouldBeNil)
+			c.So(cookie.Value, convey.ShouldEqual, "abcd")
+		})
+
+		c.Convey("OrgID, AuthProxy and JWT should set Grafana's headers", func(c convey.C) {
+			c.So(Combine(OrgID(2), AuthProxy("viewer"), JWT("eyJ")).Authenticate(req), convey.ShouldBeNil)
+			c.So(req.Header.Get("X-Grafana-Org-Id"), convey.ShouldEqual, "2")
+			c.So(req.Header.Get("X-WEBAUTH-USER"), convey.ShouldEqual, "viewer")
+			c.So(req.Header.Get("X-JWT-Assertion"), convey.ShouldEqual, "eyJ")
+		})
+
+		c.Convey("Combine should stop at the first error", func(c convey.C) {
+			failing := AuthenticatorFunc(func(req *http.Request) error { return errors.New("no credentials") })
+			err := Combine(failing, OrgID(2)).Authenticate(req)
+			c.So(err, convey.ShouldNotBeNil)
+			c.So(req.Header.Get("X-Grafana-Org-Id"), convey.ShouldBeEmpty)
+		})
+	})
+
+	convey.Convey("When fetching from Grafana with an authenticator", t, func(c convey.C) {
+		headers := []http.Header{}
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			headers = append(headers, r.Header)
+			w.Write([]byte(`{}`))
+		}))
+		defer ts.Close()
+
+		grf, err := NewV5ClientWithOptions(ts.URL, "1234", url.Values{}, false, ClientOptions{Auth: OrgID(3)})
+		c.So(err, convey.ShouldBeNil)
+		grf.GetDashboard(context.Background(), "testDash")
+		grf.RenderPanel(context.Background(), Panel{Id: 1}, "testDash", TimeRange{"now-1h", "now"}, RenderOptions{})
+
+		c.Convey("It should authenticate dashboard and render requests", func(c convey.C) {
+			c.So(headers, convey.ShouldHaveLength, 2)
+			for _, h := range headers {
+				c.So(h.Get("Authorization"), convey.ShouldEqual, "Bearer 1234")
+				c.So(h.Get("X-Grafana-Org-Id"), convey.ShouldEqual, "3")
+			}
+		})
+	})
+}
@@ -0,0 +1,431 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mlesar/grafana-report/metrics"
+)
+
+// PanelCache stores rendered panel images.
+// Implementations must be safe for concurrent use, so that one cache can be shared by many clients.
+type PanelCache interface {
+	Get(key string) (image []byte, ok bool)
+	Set(key string, image []byte)
+}
+
+type memoryCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type memoryEntry struct {
+	key     string
+	png     []byte
+	expires time.Time
+}
+
+// NewMemoryPanelCache creates an in-memory PanelCache holding at most size images.
+// The least recently used image is evicted when the cache is full.
+// Images older than ttl are not returned. A ttl of 0 means images do not expire.
+func NewMemoryPanelCache(size int, ttl time.Duration) PanelCache {
+	return &memoryCache{size: size, ttl: ttl, entries: map[string]*list.Element{}, lru: list.New()}
+}
+
+func (m *memoryCache) Get(key string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	el, ok := m.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*memoryEntry)
+	if m.ttl > 0 && time.Now().After(e.expires) {
+		m.lru.Remove(el)
+		delete(m.entries, key)
+		return nil, false
+	}
+	m.lru.MoveToFront(el)
+	return e.png, true
+}
+
+func (m *memoryCache) Set(key string, png []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e := &memoryEntry{key, png, time.Now().Add(m.ttl)}
+	if el, ok := m.entries[key]; ok {
+		el.Value = e
+		m.lru.MoveToFront(el)
+		return
+	}
+	m.entries[key] = m.lru.PushFront(e)
+	for m.lru.Len() > m.size {
+		oldest := m.lru.Back()
+		m.lru.Remove(oldest)
+		delete(m.entries, oldest.Value.(*memoryEntry).key)
+	}
+}
+
+type diskCache struct {
+	dir string
+	ttl time.Duration
+}
+
+// NewDiskPanelCache creates a PanelCache storing images as files in dir.
+// Images older than ttl are not returned and are deleted on access. A ttl of 0 means images do not expire.
+func NewDiskPanelCache(dir string, ttl time.Duration) (PanelCache, error) {
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		return nil, fmt.Errorf("creating cache directory %v: %w", dir, err)
+	}
+	return diskCache{dir, ttl}, nil
+}
+
+func (d diskCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(d.dir, hex.EncodeToString(sum[:])+".png")
+}
+
+func (d diskCache) Get(key string) ([]byte, bool) {
+	path := d.path(key)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, false
+	}
+	if d.ttl > 0 && time.Since(info.ModTime()) > d.ttl {
+		os.Remove(path)
+		return nil, false
+	}
+	png, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	return png, true
+}
+
+func (d diskCache) Set(key string, png []byte) {
+	//write to a temporary file first, so that concurrent readers never see a partial image
+	tmp, err := ioutil.TempFile(d.dir, "tmp-")
+	if err != nil {
+		logger().Warn("creating panel cache file failed", "err", err)
+		return
+	}
+	_, err = tmp.Write(png)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), d.path(key))
+	}
+	if err != nil {
+		logger().Warn("writing panel cache file failed", "err", err)
+		os.Remove(tmp.Name())
+	}
+}
+
+// innermost returns the Client wrapped by the decorators of this package
+func innermost(c Client) Client {
+	for {
+		w, ok := c.(interface{ unwrap() Client })
+		if !ok {
+			return c
+		}
+		c = w.unwrap()
+	}
+}
+
+// cacheScoper is implemented by clients that can identify the Grafana server, organisation and credentials
+// they fetch dashboards and panels with
+type cacheScoper interface {
+	cacheScope() string
+}
+
+// cacheScope returns the Grafana base URL followed by a hash of the headers the authenticator of g sets,
+// such as the API token, session cookie, auth proxy user and org id, and of the render values, e.g. orgId.
+// Cache keys start with the scope, so that clients of other servers, organisations or users sharing
+// a cache never get each other's dashboards and panels.
+func (g client) cacheScope() string {
+	req, err := http.NewRequest(http.MethodGet, g.url, nil)
+	if err != nil {
+		return g.url
+	}
+	if g.auth != nil {
+		if err := g.auth.Authenticate(req); err != nil {
+			//requests fail with these credentials, so nothing is cached in this scope
+			return g.url + "#unauthenticated"
+		}
+	}
+	h := sha256.New()
+	req.Header.Write(h)
+	io.WriteString(h, g.renderValues.Encode())
+	return g.url + "#" + hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// scopeSeq numbers the caching clients of unknown clients, which share no cache entries with any other client
+var scopeSeq int64
+
+// newCacheScope returns the cache scope of c, see client.cacheScope
+func newCacheScope(c Client) func() string {
+	if s, ok := innermost(c).(cacheScoper); ok {
+		return s.cacheScope
+	}
+	scope := fmt.Sprintf("client-%d", atomic.AddInt64(&scopeSeq, 1))
+	return func() string { return scope }
+}
+
+type cachingClient struct {
+	Client
+	cache PanelCache
+	scope func() string
+
+	mu       sync.Mutex
+	versions map[string]int
+}
+
+// panelRenderer is implemented by clients that can describe the render request for a panel
+type panelRenderer interface {
+	panelValues(p Panel, t TimeRange, opts RenderOptions) url.Values
+}
+
+// NewCachingClient wraps c so that panel images are served from cache when possible.
+// Images are keyed by Grafana server, organisation and credentials, dashboard, dashboard version, panel,
+// absolute time range, variables, size, theme and acceptable formats.
+// The dashboard version is learned from GetDashboard. Panels of dashboards that have not been fetched
+// through the returned Client are always rendered.
+func NewCachingClient(c Client, cache PanelCache) Client {
+	return &cachingClient{Client: c, cache: cache, scope: newCacheScope(c), versions: map[string]int{}}
+}
+
+func (c *cachingClient) unwrap() Client {
+	return c.Client
+}
+
+func (c *cachingClient) GetDashboard(ctx context.Context, dashName string) (Dashboard, error) {
+	dash, err := c.Client.GetDashboard(ctx, dashName)
+	if err != nil {
+		return dash, err
+	}
+	c.mu.Lock()
+	c.versions[dashName] = dash.Version
+	c.mu.Unlock()
+	return dash, nil
+}
+
+func (c *cachingClient) RenderPanel(ctx context.Context, p Panel, dashName string, t TimeRange, opts RenderOptions) (io.ReadCloser, ImageFormat, error) {
+	key, ok := c.cacheKey(p, dashName, t, opts)
+	if !ok {
+		return c.Client.RenderPanel(ctx, p, dashName, t, opts)
+	}
+	image, ok := c.cache.Get(key)
+	metrics.CacheRequests.WithLabelValues("panel", metrics.CacheResult(ok)).Inc()
+	if ok {
+		logger().Debug("using cached panel image", "dashboard", dashName, "panel", p.Id)
+		return ioutil.NopCloser(bytes.NewReader(image)), detectFormat(image), nil
+	}
+
+	body, format, err := c.Client.RenderPanel(ctx, p, dashName, t, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	defer body.Close()
+	image, err = ioutil.ReadAll(body)
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading panel image for panel %v: %v", p.Id, err)
+	}
+	c.cache.Set(key, image)
+	return ioutil.NopCloser(bytes.NewReader(image)), format, nil
+}
+
+func (c *cachingClient) cacheKey(p Panel, dashName string, t TimeRange, opts RenderOptions) (string, bool) {
+	c.mu.Lock()
+	version, ok := c.versions[dashName]
+	c.mu.Unlock()
+	//time ranges that cannot be made absolute are not cached, as the renderer interprets them
+	if !ok || !isTimeSpec(t.From) || !isTimeSpec(t.To) {
+		return "", false
+	}
+
+	values := url.Values{}
+	if r, ok := innermost(c.Client).(panelRenderer); ok {
+		values = r.panelValues(p, t, opts)
+	} else {
+		values.Set("panelId", strconv.Itoa(p.Id))
+		values.Set("theme", opts.Theme)
+	}
+	for _, f := range opts.Formats {
+		values.Add("format", string(f))
+	}
+	n := newNow()
+	values.Set("from", formatAbsTime(n.parseFrom(t.From)))
+	values.Set("to", formatAbsTime(n.parseTo(t.To)))
+	return fmt.Sprintf("%s %s/%d?%s", c.scope(), dashName, version, values.Encode()), true
+}
+
+// dashboardVersioner is implemented by clients that can look up the current version of a dashboard
+// without downloading its definition
+type dashboardVersioner interface {
+	dashboardVersion(ctx context.Context, dashName string) (int, error)
+}
+
+// errVersionUnsupported reports that the versions of dashboards cannot be looked up with the credentials of a client
+var errVersionUnsupported = errors.New("dashboard versions are not readable")
+
+// dashboardVersion looks the version of the dashboard up through the first of c and the clients it wraps
+// that implements dashboardVersioner
+func dashboardVersion(ctx context.Context, c Client, dashName string) (int, error) {
+	for {
+		if v, ok := c.(dashboardVersioner); ok {
+			return v.dashboardVersion(ctx, dashName)
+		}
+		w, ok := c.(interface{ unwrap() Client })
+		if !ok {
+			return 0, errVersionUnsupported
+		}
+		c = w.unwrap()
+	}
+}
+
+// DashboardCache holds dashboard definitions. It is safe for concurrent use and
+// can be shared by the clients of many reports. Dashboards are cached per Grafana server, organisation and credentials.
+type DashboardCache struct {
+	mu      sync.Mutex
+	maxAge  time.Duration
+	entries map[string]dashboardEntry
+	//unversioned holds the cache scopes whose credentials cannot read dashboard versions
+	unversioned map[string]bool
+}
+
+type dashboardEntry struct {
+	dash      Dashboard
+	validated time.Time
+}
+
+// NewDashboardCache creates a DashboardCache. Cached dashboards younger than maxAge are used as is,
+// older ones are revalidated against the dashboard version on the Grafana server.
+// Users who cannot save a dashboard, e.g. Viewers, cannot read its version: their cached dashboards are fetched
+// again once older than maxAge. A maxAge of 0 revalidates on every use.
+func NewDashboardCache(maxAge time.Duration) *DashboardCache {
+	return &DashboardCache{maxAge: maxAge, entries: map[string]dashboardEntry{}, unversioned: map[string]bool{}}
+}
+
+func (d *DashboardCache) get(key string) (dashboardEntry, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e, ok := d.entries[key]
+	return e, ok
+}
+
+func (d *DashboardCache) set(key string, dash Dashboard) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.entries[key] = dashboardEntry{dash, time.Now()}
+}
+
+func (d *DashboardCache) versioned(scope string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return !d.unversioned[scope]
+}
+
+func (d *DashboardCache) setUnversioned(scope string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.unversioned[scope] = true
+}
+
+type dashboardCachingClient struct {
+	Client
+	cache *DashboardCache
+	scope func() string
+}
+
+// dashboardLocator is implemented by clients that can describe where a dashboard definition is fetched from
+type dashboardLocator interface {
+	dashboardURL(dashName string) string
+}
+
+// NewDashboardCachingClient wraps c so that dashboard definitions are served from cache
+// for as long as their version on the Grafana server does not change.
+// To also cache panel images, pass the returned Client to NewCachingClient, which learns dashboard versions from it.
+func NewDashboardCachingClient(c Client, cache *DashboardCache) Client {
+	return dashboardCachingClient{c, cache, newCacheScope(c)}
+}
+
+func (c dashboardCachingClient) unwrap() Client {
+	return c.Client
+}
+
+func (c dashboardCachingClient) GetDashboard(ctx context.Context, dashName string) (Dashboard, error) {
+	//the dashboard url identifies the variables the dashboard is enriched with, the scope the credentials it is fetched with
+	key := dashName
+	if l, ok := innermost(c.Client).(dashboardLocator); ok {
+		key = l.dashboardURL(dashName)
+	}
+	scope := c.scope()
+	key = scope + " " + key
+
+	if e, ok := c.cache.get(key); ok {
+		if time.Since(e.validated) < c.cache.maxAge {
+			metrics.CacheRequests.WithLabelValues("dashboard", metrics.CacheResult(true)).Inc()
+			return e.dash, nil
+		}
+		if c.cache.versioned(scope) {
+			version, err := dashboardVersion(ctx, c.Client, dashName)
+			switch {
+			case err == nil && version == e.dash.Version:
+				metrics.CacheRequests.WithLabelValues("dashboard", metrics.CacheResult(true)).Inc()
+				c.cache.set(key, e.dash)
+				return e.dash, nil
+			case errors.Is(err, errVersionUnsupported):
+				logger().Info("dashboard versions are not readable, cached dashboards are fetched again once expired", "dashboard", dashName, "err", err)
+				c.cache.setUnversioned(scope)
+			case err != nil:
+				logger().Warn("validating cached dashboard failed, fetching it again", "dashboard", dashName, "err", err)
+			}
+		}
+	}
+
+	metrics.CacheRequests.WithLabelValues("dashboard", metrics.CacheResult(false)).Inc()
+	dash, err := c.Client.GetDashboard(ctx, dashName)
+	if err != nil {
+		return dash, err
+	}
+	c.cache.set(key, dash)
+	return dash, nil
+}
@@ -0,0 +1,297 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestPanelCaches(t *testing.T) {
+	convey.Convey("When using an in-memory panel cache", t, func(c convey.C) {
+		cache := NewMemoryPanelCache(2, 0)
+
+		c.Convey("It should return stored images", func(c convey.C) {
+			cache.Set("a", []byte("png a"))
+			png, ok := cache.Get("a")
+			c.So(ok, convey.ShouldBeTrue)
+			c.So(string(png), convey.ShouldEqual, "png a")
+		})
+
+		c.Convey("It should evict the least recently used image when full", func(c convey.C) {
+			cache.Set("a", []byte("png a"))
+			cache.Set("b", []byte("png b"))
+			cache.Get("a")
+			cache.Set("c", []byte("png c"))
+
+			_, ok := cache.Get("b")
+			c.So(ok, convey.ShouldBeFalse)
+			_, ok = cache.Get("a")
+			c.So(ok, convey.ShouldBeTrue)
+			_, ok = cache.Get("c")
+			c.So(ok, convey.ShouldBeTrue)
+		})
+
+		c.Convey("It should not return expired images", func(c convey.C) {
+			cache := NewMemoryPanelCache(2, time.Millisecond)
+			cache.Set("a", []byte("png a"))
+			time.Sleep(5 * time.Millisecond)
+			_, ok := cache.Get("a")
+			c.So(ok, convey.ShouldBeFalse)
+		})
+	})
+
+	convey.Convey("When using an on-disk panel cache", t, func(c convey.C) {
+		dir, err := ioutil.TempDir("", "panelcache")
+		c.So(err, convey.ShouldBeNil)
+		defer os.RemoveAll(dir)
+		cache, err := NewDiskPanelCache(dir, 0)
+		c.So(err, convey.ShouldBeNil)
+
+		c.Convey("It should return stored images", func(c convey.C) {
+			cache.Set("dash/1?panelId=2", []byte("png a"))
+			png, ok := cache.Get("dash/1?panelId=2")
+			c.So(ok, convey.ShouldBeTrue)
+			c.So(string(png), convey.ShouldEqual, "png a")
+		})
+
+		c.Convey("It should miss unknown keys", func(c convey.C) {
+			_, ok := cache.Get("dash/1?panelId=3")
+			c.So(ok, convey.ShouldBeFalse)
+		})
+
+		c.Convey("It should not return expired images", func(c convey.C) {
+			cache, _ := NewDiskPanelCache(dir, time.Millisecond)
+			cache.Set("a", []byte("png a"))
+			time.Sleep(5 * time.Millisecond)
+			_, ok := cache.Get("a")
+			c.So(ok, convey.ShouldBeFalse)
+		})
+	})
+}
+
+func TestCachingClient(t *testing.T) {
+	convey.Convey("When fetching panels through a caching client", t, func(c convey.C) {
+		renders := 0
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/api/dashboards/uid/testDash" {
+				fmt.Fprintln(w, `{"Dashboard":{"Uid":"testDash","Version":3}}`)
+				return
+			}
+			renders++
+			if r.URL.Query().Get("encoding") == "pdf" {
+				fmt.Fprint(w, "%PDF-1.7")
+				return
+			}
+			fmt.Fprint(w, "png")
+		}))
+		defer ts.Close()
+
+		cache := NewMemoryPanelCache(10, 0)
+		variables := url.Values{"var-host": {"servername"}}
+		absolute := TimeRange{"1453206447000", "1453213647000"}
+		panel := Panel{Id: 44, Type: "graph", Title: "title", GridPos: GridPos{6, 24, 0, 0}}
+
+		grf := NewCachingClient(NewV5Client(ts.URL, "", variables, true, false), cache)
+		grf.GetDashboard(context.Background(), "testDash")
+
+		c.Convey("The first request should render the panel", func(c convey.C) {
+			body, _, err := grf.RenderPanel(context.Background(), panel, "testDash", absolute, RenderOptions{})
+			c.So(err, convey.ShouldBeNil)
+			png, _ := ioutil.ReadAll(body)
+			c.So(string(png), convey.ShouldEqual, "png")
+			c.So(renders, convey.ShouldEqual, 1)
+		})
+
+		c.Convey("A new client sharing the cache should not render the same panel again", func(c convey.C) {
+			grf.RenderPanel(context.Background(), panel, "testDash", absolute, RenderOptions{})
+			grf = NewCachingClient(NewV5Client(ts.URL, "", variables, true, false), cache)
+			grf.GetDashboard(context.Background(), "testDash")
+			body, _, err := grf.RenderPanel(context.Background(), panel, "testDash", absolute, RenderOptions{})
+			c.So(err, convey.ShouldBeNil)
+			png, _ := ioutil.ReadAll(body)
+			c.So(string(png), convey.ShouldEqual, "png")
+			c.So(renders, convey.ShouldEqual, 1)
+		})
+
+		c.Convey("Other time ranges, variables and sizes should be rendered", func(c convey.C) {
+			grf.RenderPanel(context.Background(), panel, "testDash", absolute, RenderOptions{})
+			grf.RenderPanel(context.Background(), panel, "testDash", TimeRange{"1453206447000", "1453213648000"}, RenderOptions{})
+			other := NewCachingClient(NewV5Client(ts.URL, "", url.Values{"var-host": {"other"}}, true, false), cache)
+			other.GetDashboard(context.Background(), "testDash")
+			other.RenderPanel(context.Background(), panel, "testDash", absolute, RenderOptions{})
+			grid := NewCachingClient(NewV5Client(ts.URL, "", variables, true, true), cache)
+			grid.GetDashboard(context.Background(), "testDash")
+			grid.RenderPanel(context.Background(), panel, "testDash", absolute, RenderOptions{})
+			c.So(renders, convey.ShouldEqual, 4)
+		})
+
+		c.Convey("Other themes should be rendered", func(c convey.C) {
+			grf.RenderPanel(context.Background(), panel, "testDash", absolute, RenderOptions{})
+			grf.RenderPanel(context.Background(), panel, "testDash", absolute, RenderOptions{Theme: DarkTheme})
+			c.So(renders, convey.ShouldEqual, 2)
+		})
+
+		c.Convey("Cached images should keep their format", func(c convey.C) {
+			grf.RenderPanel(context.Background(), panel, "testDash", absolute, RenderOptions{Formats: []ImageFormat{PDF}})
+			_, format, err := grf.RenderPanel(context.Background(), panel, "testDash", absolute, RenderOptions{Formats: []ImageFormat{PDF}})
+			c.So(err, convey.ShouldBeNil)
+			c.So(format, convey.ShouldEqual, PDF)
+			c.So(renders, convey.ShouldEqual, 1)
+		})
+
+		c.Convey("Clients of other servers, organisations or credentials sharing the cache should render", func(c convey.C) {
+			other := httptest.NewServer(ts.Config.Handler)
+			defer other.Close()
+			clients := []Client{NewV5Client(other.URL, "", variables, true, false), NewV5Client(ts.URL, "token", variables, true, false)}
+			org, _ := NewV5ClientWithOptions(ts.URL, "", variables, false, ClientOptions{OrgID: 2})
+			clients = append(clients, org)
+
+			grf.RenderPanel(context.Background(), panel, "testDash", absolute, RenderOptions{})
+			for _, client := range clients {
+				grf := NewCachingClient(client, cache)
+				grf.GetDashboard(context.Background(), "testDash")
+				grf.RenderPanel(context.Background(), panel, "testDash", absolute, RenderOptions{})
+			}
+			c.So(renders, convey.ShouldEqual, 4)
+		})
+
+		c.Convey("Panels of dashboards with an unknown version should not be cached", func(c convey.C) {
+			grf := NewCachingClient(NewV5Client(ts.URL, "", variables, true, false), cache)
+			grf.RenderPanel(context.Background(), panel, "testDash", absolute, RenderOptions{})
+			grf.RenderPanel(context.Background(), panel, "testDash", absolute, RenderOptions{})
+			c.So(renders, convey.ShouldEqual, 2)
+		})
+
+		c.Convey("Panels of time ranges that cannot be made absolute should be rendered, not cached", func(c convey.C) {
+			iso := TimeRange{"2024-01-01T00:00:00Z", "now"}
+			for i := 0; i < 2; i++ {
+				body, _, err := grf.RenderPanel(context.Background(), panel, "testDash", iso, RenderOptions{})
+				c.So(err, convey.ShouldBeNil)
+				png, _ := ioutil.ReadAll(body)
+				c.So(string(png), convey.ShouldEqual, "png")
+			}
+			c.So(renders, convey.ShouldEqual, 2)
+		})
+	})
+}
+
+func TestDashboardCachingClient(t *testing.T) {
+	convey.Convey("When fetching dashboards through a dashboard caching client", t, func(c convey.C) {
+		fetches := 0
+		version := 1
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/api/dashboards/uid/testDash/versions" {
+				fmt.Fprintf(w, `[{"version":%d}]`, version)
+				return
+			}
+			fetches++
+			fmt.Fprintf(w, `{"Dashboard":{"Title":"Dash","Version":%d}}`, version)
+		}))
+		defer ts.Close()
+
+		variables := url.Values{"var-host": {"servername"}}
+
+		c.Convey("It should fetch the dashboard once while its version does not change", func(c convey.C) {
+			cache := NewDashboardCache(0)
+			NewDashboardCachingClient(NewV5Client(ts.URL, "", variables, true, false), cache).GetDashboard(context.Background(), "testDash")
+			dash, err := NewDashboardCachingClient(NewV5Client(ts.URL, "", variables, true, false), cache).GetDashboard(context.Background(), "testDash")
+			c.So(err, convey.ShouldBeNil)
+			c.So(dash.Title, convey.ShouldEqual, "Dash")
+			c.So(fetches, convey.ShouldEqual, 1)
+		})
+
+		c.Convey("It should fetch the dashboard again when its version changes", func(c convey.C) {
+			grf := NewDashboardCachingClient(NewV5Client(ts.URL, "", variables, true, false), NewDashboardCache(0))
+			grf.GetDashboard(context.Background(), "testDash")
+			version = 2
+			dash, _ := grf.GetDashboard(context.Background(), "testDash")
+			c.So(dash.Version, convey.ShouldEqual, 2)
+			c.So(fetches, convey.ShouldEqual, 2)
+		})
+
+		c.Convey("It should not revalidate dashboards younger than the maximum age", func(c convey.C) {
+			grf := NewDashboardCachingClient(NewV5Client(ts.URL, "", variables, true, false), NewDashboardCache(time.Hour))
+			grf.GetDashboard(context.Background(), "testDash")
+			version = 2
+			dash, _ := grf.GetDashboard(context.Background(), "testDash")
+			c.So(dash.Version, convey.ShouldEqual, 1)
+			c.So(fetches, convey.ShouldEqual, 1)
+		})
+
+		c.Convey("It should cache dashboards separately per variables", func(c convey.C) {
+			cache := NewDashboardCache(time.Hour)
+			NewDashboardCachingClient(NewV5Client(ts.URL, "", variables, true, false), cache).GetDashboard(context.Background(), "testDash")
+			dash, _ := NewDashboardCachingClient(NewV5Client(ts.URL, "", url.Values{"var-host": {"other"}}, true, false), cache).GetDashboard(context.Background(), "testDash")
+			c.So(dash.VariableValues, convey.ShouldEqual, "other")
+			c.So(fetches, convey.ShouldEqual, 2)
+		})
+
+		c.Convey("It should cache dashboards separately per credentials and organisation", func(c convey.C) {
+			cache := NewDashboardCache(time.Hour)
+			NewDashboardCachingClient(NewV5Client(ts.URL, "viewer", variables, true, false), cache).GetDashboard(context.Background(), "testDash")
+			NewDashboardCachingClient(NewV5Client(ts.URL, "other", variables, true, false), cache).GetDashboard(context.Background(), "testDash")
+			basic, _ := NewV5ClientWithOptions(ts.URL, "", variables, false, ClientOptions{Auth: BasicAuth("admin", "admin")})
+			NewDashboardCachingClient(basic, cache).GetDashboard(context.Background(), "testDash")
+			org, _ := NewV5ClientWithOptions(ts.URL, "viewer", variables, false, ClientOptions{OrgID: 2})
+			NewDashboardCachingClient(org, cache).GetDashboard(context.Background(), "testDash")
+			c.So(fetches, convey.ShouldEqual, 4)
+
+			NewDashboardCachingClient(NewV5Client(ts.URL, "viewer", variables, true, false), cache).GetDashboard(context.Background(), "testDash")
+			c.So(fetches, convey.ShouldEqual, 4)
+		})
+
+		c.Convey("It should remember that viewers cannot read dashboard versions", func(c convey.C) {
+			versionRequests := 0
+			viewer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/api/dashboards/uid/testDash/versions" {
+					versionRequests++
+					http.Error(w, `{"message":"Permission denied"}`, http.StatusForbidden)
+					return
+				}
+				fetches++
+				fmt.Fprintf(w, `{"Dashboard":{"Title":"Dash","Version":%d}}`, version)
+			}))
+			defer viewer.Close()
+			cache := NewDashboardCache(0)
+			for i := 0; i < 3; i++ {
+				dash, err := NewDashboardCachingClient(NewV5Client(viewer.URL, "viewer", variables, true, false), cache).GetDashboard(context.Background(), "testDash")
+				c.So(err, convey.ShouldBeNil)
+				c.So(dash.Title, convey.ShouldEqual, "Dash")
+			}
+			c.So(versionRequests, convey.ShouldEqual, 1)
+			c.So(fetches, convey.ShouldEqual, 3)
+		})
+
+		c.Convey("It should see through the panel caching client", func(c convey.C) {
+			cache := NewDashboardCache(time.Hour)
+			panels := NewMemoryPanelCache(10, 0)
+			NewDashboardCachingClient(NewCachingClient(NewV5Client(ts.URL, "", variables, true, false), panels), cache).GetDashboard(context.Background(), "testDash")
+			NewDashboardCachingClient(NewCachingClient(NewV5Client(ts.URL, "", url.Values{}, true, false), panels), cache).GetDashboard(context.Background(), "testDash")
+			c.So(fetches, convey.ShouldEqual, 2)
+		})
+	})
+}
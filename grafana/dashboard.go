@@ -18,7 +18,6 @@ package grafana
 
 import (
 	"encoding/json"
-	"log"
 	"net/url"
 	"strings"
 )
@@ -30,6 +29,7 @@ const (
 	Text
 	Graph
 	Table
+	Stat
 )
 
 func (p PanelType) string() string {
@@ -38,15 +38,73 @@ func (p PanelType) string() string {
 		"text",
 		"graph",
 		"table",
+		"stat",
 	}[p]
 }
 
 // Panel represents a Grafana dashboard panel
 type Panel struct {
-	Id      int
-	Type    string
-	Title   string
-	GridPos GridPos
+	Id               int
+	Type             string
+	Title            string
+	Description      string
+	Datasource       string //The data source name, or the uid of data source references of Grafana 8 and later
+	Unit             string //The unit of the values, e.g. percent or bytes
+	Links            []Link
+	GridPos          GridPos
+	TimeFrom         string
+	TimeShift        string
+	HideTimeOverride bool
+	PageBreak        bool   `json:"-"` //Not present in the Grafana JSON structure. Set if the panel or its row is marked with #pagebreak
+	RowTitle         string `json:"-"` //Not present in the Grafana JSON structure. The sanitized title of the row of the panel
+	Row              int    `json:"-"` //Not present in the Grafana JSON structure. The row of the panel, counting from 1, or 0 outside rows
+}
+
+// Link is a panel link. Title is sanitized for TeX, and URL is escaped for the \href command of hyperref.
+type Link struct {
+	Title string
+	URL   string
+}
+
+// UnmarshalJSON reads a panel, including the data source and the unit, which Grafana versions store differently
+func (p *Panel) UnmarshalJSON(b []byte) error {
+	type panel Panel
+	var raw struct {
+		panel
+		Datasource  json.RawMessage
+		Format      string                    //singlestat panels
+		Yaxes       []struct{ Format string } //graph panels
+		FieldConfig struct {
+			Defaults struct{ Unit string }
+		}
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	*p = Panel(raw.panel)
+	p.Datasource = datasourceName(raw.Datasource)
+	switch {
+	case raw.FieldConfig.Defaults.Unit != "":
+		p.Unit = raw.FieldConfig.Defaults.Unit
+	case raw.Format != "":
+		p.Unit = raw.Format
+	case len(raw.Yaxes) > 0:
+		p.Unit = raw.Yaxes[0].Format
+	}
+	return nil
+}
+
+// datasourceName returns the name of a data source, or the uid of a data source reference
+func datasourceName(raw json.RawMessage) string {
+	var name string
+	if json.Unmarshal(raw, &name) == nil {
+		return name
+	}
+	var ref struct{ UID string }
+	if json.Unmarshal(raw, &ref) == nil {
+		return ref.UID
+	}
+	return ""
 }
 
 // Panel represents a Grafana dashboard panel position
@@ -69,9 +127,13 @@ type Row struct {
 // This is both used to unmarshal the dashbaord JSON into
 // and then enriched (sanitize fields for TeX consumption and add VarialbeValues)
 type Dashboard struct {
+	UID            string
+	Version        int
 	Title          string
 	Description    string
-	VariableValues string //Not present in the Grafana JSON structure. Enriched data passed used by the Tex templating
+	Tags           []string
+	VariableValues string     //Not present in the Grafana JSON structure. Enriched data passed used by the Tex templating
+	Variables      url.Values `json:"-"` //Not present in the Grafana JSON structure. The variables the dashboard is rendered with, e.g. var-host=dev
 	Rows           []Row
 	Panels         []Panel
 }
@@ -79,8 +141,33 @@ type Dashboard struct {
 type dashContainer struct {
 	Dashboard Dashboard
 	Meta      struct {
-		Slug string
+		Slug    string
+		Version int
+	}
+	collapsedRows map[int][]Panel //panels nested in collapsed rows, by row id
+}
+
+// collapsedRows returns the panels Grafana 5 and later nest in collapsed rows, by row id
+func collapsedRows(dashJSON []byte) map[int][]Panel {
+	var dash struct {
+		Dashboard struct {
+			Panels []struct {
+				Id     int
+				Type   string
+				Panels []Panel
+			}
+		}
 	}
+	rows := map[int][]Panel{}
+	if json.Unmarshal(dashJSON, &dash) != nil {
+		return rows
+	}
+	for _, p := range dash.Dashboard.Panels {
+		if p.Type == "row" && len(p.Panels) > 0 {
+			rows[p.Id] = p.Panels
+		}
+	}
+	return rows
 }
 
 // NewDashboard creates Dashboard from Grafana's internal JSON dashboard definition
@@ -90,16 +177,26 @@ func NewDashboard(dashJSON []byte, variables url.Values) Dashboard {
 	if err != nil {
 		panic(err)
 	}
+	dash.collapsedRows = collapsedRows(dashJSON)
 	d := dash.NewDashboard(variables)
-	log.Printf("Populated dashboard datastructure: %+v\n", d)
+	logger().Debug("parsed dashboard", "dashboard", d.UID, "title", d.Title, "panels", len(d.Panels))
 	return d
 }
 
 func (dc dashContainer) NewDashboard(variables url.Values) Dashboard {
 	var dash Dashboard
+	dash.UID = dc.Dashboard.UID
+	dash.Version = dc.Dashboard.Version
+	if dash.Version == 0 {
+		dash.Version = dc.Meta.Version
+	}
 	dash.Title = sanitizeLaTexInput(dc.Dashboard.Title)
 	dash.Description = sanitizeLaTexInput(dc.Dashboard.Description)
+	for _, tag := range dc.Dashboard.Tags {
+		dash.Tags = append(dash.Tags, sanitizeLaTexInput(tag))
+	}
 	dash.VariableValues = sanitizeLaTexInput(getVariablesValues(variables))
+	dash.Variables = variables
 
 	if len(dc.Dashboard.Rows) == 0 {
 		return populatePanelsFromV5JSON(dash, dc)
@@ -108,10 +205,15 @@ func (dc dashContainer) NewDashboard(variables url.Values) Dashboard {
 }
 
 func populatePanelsFromV4JSON(dash Dashboard, dc dashContainer) Dashboard {
-	for _, row := range dc.Dashboard.Rows {
+	for r, row := range dc.Dashboard.Rows {
+		var rowBreak bool
+		row.Title, rowBreak = cutPageBreak(row.Title)
 		row.Title = sanitizeLaTexInput(row.Title)
 		for i, p := range row.Panels {
-			p.Title = sanitizeLaTexInput(p.Title)
+			p = sanitizePanel(p)
+			p.PageBreak = p.PageBreak || (i == 0 && rowBreak)
+			p.RowTitle = row.Title
+			p.Row = r + 1
 			row.Panels[i] = p
 			dash.Panels = append(dash.Panels, p)
 		}
@@ -122,18 +224,84 @@ func populatePanelsFromV4JSON(dash Dashboard, dc dashContainer) Dashboard {
 }
 
 func populatePanelsFromV5JSON(dash Dashboard, dc dashContainer) Dashboard {
+	var rowBreak bool
+	var rowTitle string
+	var row, rowStart int
 	for _, p := range dc.Dashboard.Panels {
 		if p.Type == "row" {
+			breakFirstPanel(dash.Panels[rowStart:], rowBreak)
+			row++
+			rowStart = len(dash.Panels)
+			rowTitle, rowBreak = cutPageBreak(p.Title)
+			rowTitle = sanitizeLaTexInput(rowTitle)
+			for _, nested := range dc.collapsedRows[p.Id] {
+				nested = sanitizePanel(nested)
+				nested.RowTitle = rowTitle
+				nested.Row = row
+				dash.Panels = append(dash.Panels, nested)
+			}
 			continue
 		}
-		p.Title = sanitizeLaTexInput(p.Title)
+		p = sanitizePanel(p)
+		p.RowTitle = rowTitle
+		p.Row = row
 		dash.Panels = append(dash.Panels, p)
 	}
+	breakFirstPanel(dash.Panels[rowStart:], rowBreak)
 	return dash
 }
 
+// breakFirstPanel marks the first of the panels of a row in grid order, top to bottom and left to right,
+// to start a new page if rowBreak is set. The JSON order of panels need not be the grid order.
+func breakFirstPanel(panels []Panel, rowBreak bool) {
+	if !rowBreak || len(panels) == 0 {
+		return
+	}
+	first := 0
+	for i, p := range panels {
+		pos := panels[first].GridPos
+		if p.GridPos.Y < pos.Y || (p.GridPos.Y == pos.Y && p.GridPos.X < pos.X) {
+			first = i
+		}
+	}
+	panels[first].PageBreak = true
+}
+
+// pageBreakMarker marks a panel description or a row title to start the panel or the row on a new page
+const pageBreakMarker = "#pagebreak"
+
+// cutPageBreak removes the page break marker from s, and reports whether it was found
+func cutPageBreak(s string) (string, bool) {
+	if !strings.Contains(s, pageBreakMarker) {
+		return s, false
+	}
+	return strings.TrimSpace(strings.Replace(s, pageBreakMarker, "", -1)), true
+}
+
+// sanitizePanel reads the page break marker of the panel, and sanitizes its text and links for TeX
+func sanitizePanel(p Panel) Panel {
+	p.Description, p.PageBreak = cutPageBreak(p.Description)
+	p.Title = sanitizeLaTexInput(p.Title)
+	p.Description = sanitizeLaTexInput(p.Description)
+	p.Datasource = sanitizeLaTexInput(p.Datasource)
+	p.Unit = sanitizeLaTexInput(p.Unit)
+	links := make([]Link, 0, len(p.Links))
+	for _, l := range p.Links {
+		links = append(links, Link{Title: sanitizeLaTexInput(l.Title), URL: escapeURL(l.URL)})
+	}
+	p.Links = links
+	return p
+}
+
+// escapeURL escapes the characters of url that \href requires escaped, and percent-encodes those it cannot take escaped
+func escapeURL(url string) string {
+	return strings.NewReplacer("%", "\\%", "#", "\\#",
+		"\\", "\\%5C", "{", "\\%7B", "}", "\\%7D", "~", "\\%7E", "^", "\\%5E").Replace(url)
+}
+
+// IsSingleStat reports whether the panel shows a single value, as the singlestat panel and the stat panel of Grafana 7 and later do
 func (p Panel) IsSingleStat() bool {
-	return p.Is(SingleStat)
+	return p.Is(SingleStat) || p.Is(Stat)
 }
 
 func (p Panel) IsPartialWidth() bool {
@@ -148,6 +316,16 @@ func (p Panel) Height() float64 {
 	return float64(p.GridPos.H) * 0.04
 }
 
+// HasTimeOverride reports whether the panel overrides the dashboard time range
+func (p Panel) HasTimeOverride() bool {
+	return p.TimeFrom != "" || p.TimeShift != ""
+}
+
+// EffectiveTimeRange returns the time range the panel displays when the dashboard displays t
+func (p Panel) EffectiveTimeRange(t TimeRange) TimeRange {
+	return t.Override(p.TimeFrom, p.TimeShift)
+}
+
 func (p Panel) Is(t PanelType) bool {
 	if p.Type == t.string() {
 		return true
@@ -167,6 +345,11 @@ func getVariablesValues(variables url.Values) string {
 	return strings.Join(values, ", ")
 }
 
+// EscapeLaTeX escapes the characters of input that are special to LaTeX
+func EscapeLaTeX(input string) string {
+	return sanitizeLaTexInput(input)
+}
+
 func sanitizeLaTexInput(input string) string {
 	input = strings.Replace(input, "\\", "\\textbackslash ", -1)
 	input = strings.Replace(input, "&", "\\&", -1)
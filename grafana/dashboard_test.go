@@ -56,6 +56,7 @@ func TestV4Dashboard(t *testing.T) {
 
 		c.Convey("Row title should be parsed and santised", func(c convey.C) {
 			c.So(dash.Rows[0].Title, convey.ShouldEqual, "RowTitle \\#")
+			c.So(dash.Panels[0].Row, convey.ShouldEqual, 1)
 		})
 
 		c.Convey("Panel titles should be parsed and sanitised", func(c convey.C) {
@@ -86,7 +87,7 @@ func TestV5Dashboard(t *testing.T) {
 			{"Type":"graph", "Id":1, "GridPos":{"H":6,"W":24,"X":0,"Y":0}},
 			{"Type":"singlestat", "Id":2, "Title":"Panel3Title #"},
 			{"Type":"text", "GridPos":{"H":6.5,"W":20.5,"X":0,"Y":0}, "Id":3},
-			{"Type":"table", "Id":4},
+			{"Type":"table", "Id":4, "timeFrom":"2h", "timeShift":"1d", "hideTimeOverride":true},
 			{"Type":"row", "Id":5}],
 		"Title":"DashTitle #"
 	},
@@ -129,6 +130,167 @@ func TestV5Dashboard(t *testing.T) {
 			c.So(dash.Panels[3].GridPos.W, convey.ShouldEqual, 20.5)
 		})
 
+		c.Convey("Panels should contain time overrides", func(c convey.C) {
+			c.So(dash.Panels[3].HasTimeOverride(), convey.ShouldBeFalse)
+			c.So(dash.Panels[4].HasTimeOverride(), convey.ShouldBeTrue)
+			c.So(dash.Panels[4].TimeFrom, convey.ShouldEqual, "2h")
+			c.So(dash.Panels[4].TimeShift, convey.ShouldEqual, "1d")
+			c.So(dash.Panels[4].HideTimeOverride, convey.ShouldBeTrue)
+		})
+
+	})
+}
+
+func TestModernDashboard(t *testing.T) {
+	convey.Convey("When creating a new dashboard from Grafana 8+ dashboard JSON", t, func(c convey.C) {
+		const modernDashJSON = `
+{"dashboard":
+	{
+		"uid":"modern",
+		"schemaVersion":39,
+		"tags":["weekly", "team_a"],
+		"panels":
+			[{"type":"stat", "id":1, "gridPos":{"h":4,"w":6,"x":0,"y":0}},
+			{"type":"row", "id":2, "collapsed":true, "title":"Collapsed",
+				"panels":[{"type":"timeseries", "id":3, "title":"Nested #", "datasource":{"type":"prometheus","uid":"abc"}}]},
+			{"type":"row", "id":4, "collapsed":false, "panels":[]},
+			{"type":"table", "id":5}]
+	},
+"meta":{"version":7}
+}`
+		dash := NewDashboard([]byte(modernDashJSON), url.Values{})
+
+		c.Convey("Panels nested in collapsed rows should be included in order", func(c convey.C) {
+			c.So(dash.Panels, convey.ShouldHaveLength, 3)
+			c.So(dash.Panels[0].Id, convey.ShouldEqual, 1)
+			c.So(dash.Panels[1].Id, convey.ShouldEqual, 3)
+			c.So(dash.Panels[2].Id, convey.ShouldEqual, 5)
+			c.So(dash.Panels[1].Title, convey.ShouldEqual, "Nested \\#")
+		})
+
+		c.Convey("Stat panels should be single stats", func(c convey.C) {
+			c.So(dash.Panels[0].IsSingleStat(), convey.ShouldBeTrue)
+			c.So(dash.Panels[1].IsSingleStat(), convey.ShouldBeFalse)
+		})
+
+		c.Convey("The version should be read from the meta data", func(c convey.C) {
+			c.So(dash.Version, convey.ShouldEqual, 7)
+		})
+
+		c.Convey("Tags should be parsed and sanitised", func(c convey.C) {
+			c.So(dash.Tags, convey.ShouldResemble, []string{"weekly", "team\\_a"})
+		})
+
+		c.Convey("Panels should know the title of their row", func(c convey.C) {
+			c.So(dash.Panels[0].RowTitle, convey.ShouldEqual, "")
+			c.So(dash.Panels[1].RowTitle, convey.ShouldEqual, "Collapsed")
+			c.So(dash.Panels[2].RowTitle, convey.ShouldEqual, "")
+		})
+
+		c.Convey("Panels should know their row, including untitled rows", func(c convey.C) {
+			c.So(dash.Panels[0].Row, convey.ShouldEqual, 0)
+			c.So(dash.Panels[1].Row, convey.ShouldEqual, 1)
+			c.So(dash.Panels[2].Row, convey.ShouldEqual, 2)
+		})
+	})
+}
+
+func TestPanelDetails(t *testing.T) {
+	convey.Convey("When creating a dashboard with panel details", t, func(c convey.C) {
+		const detailedDashJSON = `
+{"dashboard":
+	{
+		"panels":
+			[{"type":"timeseries", "id":1, "description":"CPU_usage", "datasource":{"type":"prometheus","uid":"prom_1"},
+				"fieldConfig":{"defaults":{"unit":"percent"}},
+				"links":[{"title":"Runbook #1", "url":"https://wiki/runbook#cpu?q=100%"}]},
+			{"type":"singlestat", "id":2, "datasource":"Graphite & co", "format":"bytes"},
+			{"type":"graph", "id":3, "datasource":null, "yaxes":[{"format":"ms"},{"format":"short"}]}]
+	}
+}`
+		dash := NewDashboard([]byte(detailedDashJSON), url.Values{})
+
+		c.Convey("The description, data source and unit should be parsed and sanitised", func(c convey.C) {
+			c.So(dash.Panels[0].Description, convey.ShouldEqual, "CPU\\_usage")
+			c.So(dash.Panels[0].Datasource, convey.ShouldEqual, "prom\\_1")
+			c.So(dash.Panels[0].Unit, convey.ShouldEqual, "percent")
+			c.So(dash.Panels[1].Datasource, convey.ShouldEqual, "Graphite \\& co")
+			c.So(dash.Panels[1].Unit, convey.ShouldEqual, "bytes")
+			c.So(dash.Panels[2].Datasource, convey.ShouldEqual, "")
+			c.So(dash.Panels[2].Unit, convey.ShouldEqual, "ms")
+		})
+
+		c.Convey("Links should be parsed and escaped", func(c convey.C) {
+			c.So(dash.Panels[0].Links, convey.ShouldResemble, []Link{{Title: "Runbook \\#1", URL: "https://wiki/runbook\\#cpu?q=100\\%"}})
+			c.So(dash.Panels[1].Links, convey.ShouldBeEmpty)
+		})
+
+		c.Convey("Link URLs should percent-encode the characters that \\href cannot take", func(c convey.C) {
+			c.So(escapeURL(`http://x}\foo{~^`), convey.ShouldEqual, `http://x\%7D\%5Cfoo\%7B\%7E\%5E`)
+		})
+	})
+}
+
+func TestPageBreakMarkers(t *testing.T) {
+	convey.Convey("When creating a dashboard with page break markers", t, func(c convey.C) {
+		const markedDashJSON = `
+{"dashboard":
+	{
+		"panels":
+			[{"type":"graph", "id":1, "description":"Requests per second #pagebreak"},
+			{"type":"graph", "id":2, "description":"Latency & errors"},
+			{"type":"row", "id":3, "title":"Storage #pagebreak"},
+			{"type":"graph", "id":4},
+			{"type":"graph", "id":5},
+			{"type":"row", "id":6, "collapsed":true, "title":"#pagebreak",
+				"panels":[{"type":"graph", "id":7}, {"type":"graph", "id":8}]}]
+	}
+}`
+		const markedV4DashJSON = `
+{"Dashboard":
+	{
+		"Rows":
+			[{"Title":"First", "Panels":[{"Type":"graph", "Id":1}]},
+			{"Title":"Second #pagebreak", "Panels":[{"Type":"graph", "Id":2}, {"Type":"graph", "Id":3}]}]
+	}
+}`
+		dash := NewDashboard([]byte(markedDashJSON), url.Values{})
+
+		c.Convey("Panels marked in their description should break pages", func(c convey.C) {
+			c.So(dash.Panels[0].PageBreak, convey.ShouldBeTrue)
+			c.So(dash.Panels[0].Description, convey.ShouldEqual, "Requests per second")
+			c.So(dash.Panels[1].PageBreak, convey.ShouldBeFalse)
+			c.So(dash.Panels[1].Description, convey.ShouldEqual, "Latency \\& errors")
+		})
+
+		c.Convey("The first panel of marked rows should break pages", func(c convey.C) {
+			var breaks []int
+			for _, p := range dash.Panels {
+				if p.PageBreak {
+					breaks = append(breaks, p.Id)
+				}
+			}
+			c.So(breaks, convey.ShouldResemble, []int{1, 4, 7})
+		})
+
+		c.Convey("The first panel of marked rows in grid order should break pages", func(c convey.C) {
+			dash := NewDashboard([]byte(`{"dashboard":{"panels":[
+				{"type":"graph", "id":1, "gridPos":{"h":8,"w":24,"x":0,"y":0}},
+				{"type":"row", "id":2, "title":"#pagebreak", "gridPos":{"h":1,"w":24,"x":0,"y":8}},
+				{"type":"graph", "id":3, "gridPos":{"h":8,"w":12,"x":12,"y":9}},
+				{"type":"graph", "id":4, "gridPos":{"h":8,"w":12,"x":0,"y":9}}]}}`), url.Values{})
+			c.So(dash.Panels[1].Id, convey.ShouldEqual, 3)
+			c.So(dash.Panels[1].PageBreak, convey.ShouldBeFalse)
+			c.So(dash.Panels[2].PageBreak, convey.ShouldBeTrue)
+		})
+
+		c.Convey("The first panel of marked Grafana 4 rows should break pages", func(c convey.C) {
+			dash := NewDashboard([]byte(markedV4DashJSON), url.Values{})
+			c.So(dash.Rows[1].Title, convey.ShouldEqual, "Second")
+			c.So(dash.Panels[0].PageBreak, convey.ShouldBeFalse)
+			c.So(dash.Panels[1].PageBreak, convey.ShouldBeTrue)
+			c.So(dash.Panels[2].PageBreak, convey.ShouldBeFalse)
+		})
 	})
 }
 
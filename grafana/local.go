@@ -0,0 +1,324 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pborman/uuid"
+)
+
+// LocalClient serves dashboard definitions from local JSON files, and renders their panels through a Grafana server
+// by pushing each definition to it as a temporary dashboard. Other dashboards are fetched from the Grafana server.
+// Files are read on each use, so that reports follow edits to them. The Version of a local dashboard is derived
+// from the content of its file rather than from its version field, so that caches see edits that keep the field.
+// Reports delete the temporary dashboard once their panels are rendered, see Release. Reports rendering the same
+// local dashboard concurrently share its temporary dashboard, which is deleted once none of them renders from it.
+// Call Close() to delete the temporary dashboards left by rendering panels without a report.
+type LocalClient struct {
+	Client
+	dashboards map[string]string //dashboard files by name
+
+	mu     sync.Mutex
+	pushed map[string]*pushedDashboard //temporary dashboards by local name
+}
+
+// pushedDashboard is the temporary dashboard of a local dashboard on the Grafana server
+type pushedDashboard struct {
+	name     string
+	renders  int  //renders in flight from the dashboard
+	released bool //the dashboard is deleted once no render is in flight
+}
+
+// dashboardPusher is implemented by clients that can create and delete dashboards on the Grafana server
+type dashboardPusher interface {
+	newDashboard(dashJSON []byte) Dashboard
+	pushDashboard(ctx context.Context, model map[string]interface{}) (name string, err error)
+	deleteDashboard(ctx context.Context, name string) error
+}
+
+// NewLocalClient creates a LocalClient serving the dashboards in paths, rendering them through c.
+// A path is either a dashboard JSON file, or a directory, such as a Grafana provisioning directory, whose JSON files are read recursively.
+// Files may contain a dashboard model as provisioned, or as exported with its metadata by the dashboard API.
+// Dashboards are named by their uid, or by their file name without the extension if they have none.
+func NewLocalClient(c Client, paths ...string) (*LocalClient, error) {
+	if _, ok := innermost(c).(dashboardPusher); !ok {
+		return nil, errors.New("client cannot push dashboards to Grafana")
+	}
+	l := &LocalClient{Client: c, dashboards: map[string]string{}, pushed: map[string]*pushedDashboard{}}
+	for _, path := range paths {
+		err := filepath.WalkDir(path, func(file string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || (file != path && filepath.Ext(file) != ".json") {
+				return nil
+			}
+			return l.load(file)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("loading dashboards from %v: %w", path, err)
+		}
+	}
+	return l, nil
+}
+
+func (l *LocalClient) load(file string) error {
+	model, _, err := readModel(file)
+	if err != nil {
+		return err
+	}
+	name, _ := model["uid"].(string)
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	}
+	if _, ok := l.dashboards[name]; ok {
+		return fmt.Errorf("dashboard %v in %v is already loaded from another file", name, file)
+	}
+	l.dashboards[name] = file
+	logger().Debug("loaded local dashboard", "dashboard", name, "file", file)
+	return nil
+}
+
+// readModel reads the dashboard model of a provisioned or exported dashboard file, and the version of the file,
+// a hash of its content
+func readModel(file string) (map[string]interface{}, int, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, 0, err
+	}
+	var model map[string]interface{}
+	if err = json.Unmarshal(content, &model); err != nil {
+		return nil, 0, fmt.Errorf("parsing dashboard %v: %w", file, err)
+	}
+	if exported, ok := model["dashboard"].(map[string]interface{}); ok {
+		model = exported
+	}
+	h := fnv.New32a()
+	h.Write(content)
+	return model, int(h.Sum32()), nil
+}
+
+func (l *LocalClient) unwrap() Client {
+	return l.Client
+}
+
+// Dashboards returns the sorted names of the local dashboards
+func (l *LocalClient) Dashboards() []string {
+	var names []string
+	for name := range l.dashboards {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (l *LocalClient) GetDashboard(ctx context.Context, dashName string) (Dashboard, error) {
+	file, ok := l.dashboards[dashName]
+	if !ok {
+		return l.Client.GetDashboard(ctx, dashName)
+	}
+	model, version, err := readModel(file)
+	if err != nil {
+		return Dashboard{}, fmt.Errorf("error reading local dashboard %v: %w", dashName, err)
+	}
+	dashJSON, err := json.Marshal(map[string]interface{}{"dashboard": model})
+	if err != nil {
+		return Dashboard{}, fmt.Errorf("error encoding local dashboard %v: %v", dashName, err)
+	}
+	dash := innermost(l.Client).(dashboardPusher).newDashboard(dashJSON)
+	dash.Version = version
+	return dash, nil
+}
+
+func (l *LocalClient) dashboardVersion(ctx context.Context, dashName string) (int, error) {
+	file, ok := l.dashboards[dashName]
+	if !ok {
+		return dashboardVersion(ctx, l.Client, dashName)
+	}
+	_, version, err := readModel(file)
+	if err != nil {
+		return 0, fmt.Errorf("error reading local dashboard %v: %w", dashName, err)
+	}
+	return version, nil
+}
+
+func (l *LocalClient) RenderPanel(ctx context.Context, p Panel, dashName string, t TimeRange, opts RenderOptions) (io.ReadCloser, ImageFormat, error) {
+	if _, ok := l.dashboards[dashName]; !ok {
+		return l.Client.RenderPanel(ctx, p, dashName, t, opts)
+	}
+	pushed, err := l.push(ctx, dashName)
+	if err != nil {
+		return nil, "", err
+	}
+	//Grafana has rendered the panel once it responds, the body does not need the dashboard
+	defer l.done(ctx, dashName, pushed)
+	return l.Client.RenderPanel(ctx, p, pushed.name, t, opts)
+}
+
+// push creates the temporary dashboard of the local dashboard on first use, and counts a render in flight from it
+func (l *LocalClient) push(ctx context.Context, dashName string) (*pushedDashboard, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if pushed, ok := l.pushed[dashName]; ok {
+		pushed.renders++
+		return pushed, nil
+	}
+
+	model, _, err := readModel(l.dashboards[dashName])
+	if err != nil {
+		return nil, fmt.Errorf("error reading local dashboard %v: %w", dashName, err)
+	}
+	uid := "report-" + uuid.New()[:8]
+	title, _ := model["title"].(string)
+	model["id"] = nil
+	model["uid"] = uid
+	model["title"] = fmt.Sprintf("%s (report preview %s)", title, uid) //titles must be unique in a folder
+
+	tmpName, err := innermost(l.Client).(dashboardPusher).pushDashboard(ctx, model)
+	if err != nil {
+		return nil, fmt.Errorf("error pushing local dashboard %v to Grafana: %w", dashName, err)
+	}
+	logger().Debug("pushed temporary dashboard", "dashboard", dashName, "temporary", tmpName)
+	pushed := &pushedDashboard{name: tmpName, renders: 1}
+	l.pushed[dashName] = pushed
+	return pushed, nil
+}
+
+// done counts a render from the temporary dashboard as finished, and deletes the dashboard if it was released
+func (l *LocalClient) done(ctx context.Context, dashName string, pushed *pushedDashboard) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	pushed.renders--
+	if !pushed.released || pushed.renders > 0 {
+		return
+	}
+	//the render may have been cancelled, the dashboard is deleted regardless
+	if err := l.delete(context.WithoutCancel(ctx), dashName, pushed); err != nil {
+		logger().Warn("deleting temporary dashboard failed", "dashboard", dashName, "err", err)
+	}
+}
+
+// delete deletes the temporary dashboard of dashName. l.mu is held.
+func (l *LocalClient) delete(ctx context.Context, dashName string, pushed *pushedDashboard) error {
+	if err := innermost(l.Client).(dashboardPusher).deleteDashboard(ctx, pushed.name); err != nil {
+		return fmt.Errorf("error deleting temporary dashboard %v of %v: %w", pushed.name, dashName, err)
+	}
+	if l.pushed[dashName] == pushed {
+		delete(l.pushed, dashName)
+	}
+	return nil
+}
+
+// Release deletes the temporary dashboard of the local dashboard dashName from the Grafana server, if it was pushed.
+// If panels of the dashboard are being rendered, for example by another report, it is deleted once they are rendered.
+// Rendering its panels again pushes the dashboard again.
+func (l *LocalClient) Release(ctx context.Context, dashName string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	pushed, ok := l.pushed[dashName]
+	if !ok {
+		return nil
+	}
+	if pushed.renders > 0 {
+		pushed.released = true
+		return nil
+	}
+	return l.delete(ctx, dashName, pushed)
+}
+
+// Release frees what c holds on to for rendering the panels of the dashboard dashName, such as the temporary
+// dashboard of a LocalClient. It sees through the clients of this package wrapping c, e.g. caching clients.
+// Reports release their dashboard once its panels are rendered.
+func Release(ctx context.Context, c Client, dashName string) error {
+	for {
+		if r, ok := c.(interface {
+			Release(ctx context.Context, dashName string) error
+		}); ok {
+			return r.Release(ctx, dashName)
+		}
+		w, ok := c.(interface{ unwrap() Client })
+		if !ok {
+			return nil
+		}
+		c = w.unwrap()
+	}
+}
+
+// Close deletes the temporary dashboards from the Grafana server
+func (l *LocalClient) Close(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var errs []error
+	for dashName, pushed := range l.pushed {
+		if err := l.delete(ctx, dashName, pushed); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (g client) newDashboard(dashJSON []byte) Dashboard {
+	return NewDashboard(dashJSON, g.variables)
+}
+
+// pushDashboard creates the dashboard, and returns the name it is addressed by
+func (g client) pushDashboard(ctx context.Context, model map[string]interface{}) (string, error) {
+	reqBody, err := json.Marshal(map[string]interface{}{
+		"dashboard": model,
+		"overwrite": true,
+		"message":   "temporary dashboard for report generation",
+	})
+	if err != nil {
+		return "", fmt.Errorf("error encoding dashboard: %v", err)
+	}
+	body, err := g.do(ctx, "pushDashboard", "POST", g.url+"/api/dashboards/db", reqBody)
+	if err != nil {
+		return "", err
+	}
+	var created struct {
+		UID  string
+		Slug string
+	}
+	if err = json.Unmarshal(body, &created); err != nil {
+		return "", fmt.Errorf("error parsing pushDashboard response: %v", err)
+	}
+	if g.slugNames {
+		return created.Slug, nil
+	}
+	return created.UID, nil
+}
+
+func (g client) deleteDashboard(ctx context.Context, name string) error {
+	deleteURL := g.url + "/api/dashboards/uid/" + name
+	if g.slugNames {
+		deleteURL = g.url + "/api/dashboards/db/" + name
+	}
+	_, err := g.do(ctx, "deleteDashboard", "DELETE", deleteURL, nil)
+	return err
+}
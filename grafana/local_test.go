@@ -0,0 +1,169 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestLocalClient(t *testing.T) {
+	convey.Convey("When rendering dashboards from local JSON files", t, func(c convey.C) {
+		dir, err := ioutil.TempDir("", "dashboards")
+		c.So(err, convey.ShouldBeNil)
+		defer os.RemoveAll(dir)
+		os.MkdirAll(filepath.Join(dir, "team"), 0777)
+		ioutil.WriteFile(filepath.Join(dir, "provisioned.json"), []byte(`{"uid":"prov","title":"Provisioned","version":4,"panels":[{"type":"graph","id":1}]}`), 0666)
+		ioutil.WriteFile(filepath.Join(dir, "team", "exported.json"), []byte(`{"meta":{"slug":"exported"},"dashboard":{"title":"Exported","panels":[{"type":"graph","id":2}]}}`), 0666)
+		ioutil.WriteFile(filepath.Join(dir, "readme.txt"), []byte("not a dashboard"), 0666)
+
+		var pushed map[string]interface{}
+		var requests []string
+		var rendering, unblock chan struct{} //if set, renders signal rendering and wait for unblock
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r.Method+" "+r.URL.Path)
+			switch {
+			case r.Method == "POST" && r.URL.Path == "/api/dashboards/db":
+				var req struct{ Dashboard map[string]interface{} }
+				json.NewDecoder(r.Body).Decode(&req)
+				pushed = req.Dashboard
+				fmt.Fprintf(w, `{"id":10,"uid":"%s","slug":"tmp","status":"success","version":1}`, pushed["uid"])
+			case r.URL.Path == "/api/dashboards/uid/remote":
+				fmt.Fprint(w, `{"dashboard":{"title":"Remote"}}`)
+			default:
+				if rendering != nil && r.Method == "GET" {
+					rendering <- struct{}{}
+					<-unblock
+				}
+				fmt.Fprint(w, "png")
+			}
+		}))
+		defer ts.Close()
+
+		local, err := NewLocalClient(NewV5Client(ts.URL, "", url.Values{"var-host": {"servername"}}, true, false), dir)
+		c.So(err, convey.ShouldBeNil)
+
+		c.Convey("It should load provisioned and exported dashboards from the directory tree", func(c convey.C) {
+			c.So(local.Dashboards(), convey.ShouldResemble, []string{"exported", "prov"})
+		})
+
+		c.Convey("It should serve local dashboard definitions without contacting Grafana", func(c convey.C) {
+			dash, err := local.GetDashboard(context.Background(), "prov")
+			c.So(err, convey.ShouldBeNil)
+			c.So(dash.Title, convey.ShouldEqual, "Provisioned")
+			c.So(dash.VariableValues, convey.ShouldEqual, "servername")
+			c.So(dash.Panels, convey.ShouldHaveLength, 1)
+			version, err := local.dashboardVersion(context.Background(), "prov")
+			c.So(err, convey.ShouldBeNil)
+			c.So(version, convey.ShouldEqual, dash.Version)
+			c.So(requests, convey.ShouldBeEmpty)
+		})
+
+		c.Convey("It should fetch other dashboards from Grafana", func(c convey.C) {
+			dash, err := local.GetDashboard(context.Background(), "remote")
+			c.So(err, convey.ShouldBeNil)
+			c.So(dash.Title, convey.ShouldEqual, "Remote")
+		})
+
+		c.Convey("It should push a temporary dashboard once and render panels from it", func(c convey.C) {
+			local.RenderPanel(context.Background(), Panel{Id: 1, Type: "graph"}, "prov", TimeRange{"now-1h", "now"}, RenderOptions{})
+			local.RenderPanel(context.Background(), Panel{Id: 2, Type: "graph"}, "prov", TimeRange{"now-1h", "now"}, RenderOptions{})
+			tmpUID := pushed["uid"].(string)
+			c.So(tmpUID, convey.ShouldStartWith, "report-")
+			c.So(pushed["id"], convey.ShouldBeNil)
+			c.So(pushed["title"], convey.ShouldContainSubstring, "Provisioned")
+			c.So(requests, convey.ShouldResemble, []string{"POST /api/dashboards/db", "GET /render/d-solo/" + tmpUID + "/_", "GET /render/d-solo/" + tmpUID + "/_"})
+
+			c.Convey("Close should delete the temporary dashboard", func(c convey.C) {
+				c.So(local.Close(context.Background()), convey.ShouldBeNil)
+				c.So(requests[len(requests)-1], convey.ShouldEqual, "DELETE /api/dashboards/uid/"+tmpUID)
+			})
+		})
+
+		c.Convey("Release should delete the temporary dashboard of one dashboard through wrapping clients", func(c convey.C) {
+			local.RenderPanel(context.Background(), Panel{Id: 1, Type: "graph"}, "prov", TimeRange{"now-1h", "now"}, RenderOptions{})
+			tmpUID := pushed["uid"].(string)
+			c.So(Release(context.Background(), NewCachingClient(local, NewMemoryPanelCache(10, 0)), "prov"), convey.ShouldBeNil)
+			c.So(requests[len(requests)-1], convey.ShouldEqual, "DELETE /api/dashboards/uid/"+tmpUID)
+
+			c.So(Release(context.Background(), local, "prov"), convey.ShouldBeNil)
+			c.So(Release(context.Background(), NewV5Client(ts.URL, "", url.Values{}, true, false), "prov"), convey.ShouldBeNil)
+			c.So(requests[len(requests)-1], convey.ShouldEqual, "DELETE /api/dashboards/uid/"+tmpUID)
+		})
+
+		c.Convey("Release should keep the temporary dashboard until other renders from it finish", func(c convey.C) {
+			local.RenderPanel(context.Background(), Panel{Id: 1, Type: "graph"}, "prov", TimeRange{"now-1h", "now"}, RenderOptions{})
+			tmpUID := pushed["uid"].(string)
+			rendering, unblock = make(chan struct{}), make(chan struct{})
+			done := make(chan error)
+			go func() {
+				_, _, err := local.RenderPanel(context.Background(), Panel{Id: 2, Type: "graph"}, "prov", TimeRange{"now-1h", "now"}, RenderOptions{})
+				done <- err
+			}()
+			<-rendering
+			c.So(local.Release(context.Background(), "prov"), convey.ShouldBeNil)
+			c.So(requests[len(requests)-1], convey.ShouldEqual, "GET /render/d-solo/"+tmpUID+"/_")
+			close(unblock)
+			c.So(<-done, convey.ShouldBeNil)
+			c.So(requests[len(requests)-1], convey.ShouldEqual, "DELETE /api/dashboards/uid/"+tmpUID)
+			c.So(local.Close(context.Background()), convey.ShouldBeNil)
+			c.So(requests[len(requests)-1], convey.ShouldEqual, "DELETE /api/dashboards/uid/"+tmpUID)
+		})
+
+		c.Convey("It should read edits of the local files", func(c convey.C) {
+			ioutil.WriteFile(filepath.Join(dir, "provisioned.json"), []byte(`{"uid":"prov","title":"Edited","version":5}`), 0666)
+			dash, err := local.GetDashboard(context.Background(), "prov")
+			c.So(err, convey.ShouldBeNil)
+			c.So(dash.Title, convey.ShouldEqual, "Edited")
+			local.RenderPanel(context.Background(), Panel{Id: 1, Type: "graph"}, "prov", TimeRange{"now-1h", "now"}, RenderOptions{})
+			c.So(pushed["title"], convey.ShouldStartWith, "Edited")
+		})
+
+		c.Convey("Caches should see edits that keep the version field of the local files", func(c convey.C) {
+			cached := NewDashboardCachingClient(local, NewDashboardCache(0))
+			dash, _ := cached.GetDashboard(context.Background(), "prov")
+			c.So(dash.Title, convey.ShouldEqual, "Provisioned")
+			ioutil.WriteFile(filepath.Join(dir, "provisioned.json"), []byte(`{"uid":"prov","title":"Edited","version":4}`), 0666)
+			edited, _ := cached.GetDashboard(context.Background(), "prov")
+			c.So(edited.Title, convey.ShouldEqual, "Edited")
+			c.So(edited.Version, convey.ShouldNotEqual, dash.Version)
+		})
+
+		c.Convey("It should not change the local definition", func(c convey.C) {
+			local.RenderPanel(context.Background(), Panel{Id: 1, Type: "graph"}, "prov", TimeRange{"now-1h", "now"}, RenderOptions{})
+			dash, _ := local.GetDashboard(context.Background(), "prov")
+			c.So(strings.Contains(dash.Title, "preview"), convey.ShouldBeFalse)
+		})
+
+		c.Convey("It should fail on dashboards with the same name", func(c convey.C) {
+			ioutil.WriteFile(filepath.Join(dir, "team", "prov.json"), []byte(`{"uid":"prov"}`), 0666)
+			_, err := NewLocalClient(NewV5Client(ts.URL, "", url.Values{}, true, false), dir)
+			c.So(err, convey.ShouldNotBeNil)
+		})
+	})
+}
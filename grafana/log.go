@@ -0,0 +1,72 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"errors"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync/atomic"
+)
+
+var customLogger atomic.Pointer[slog.Logger]
+
+// SetLogger sets the structured logger used by the Grafana client, and by reports unless given their own logger.
+// It is safe to call at any time, also while reports are generated.
+// If it is not set, or set to nil, slog.Default() is used. Progress is logged at debug level.
+func SetLogger(l *slog.Logger) {
+	customLogger.Store(l)
+}
+
+// Logger returns the logger set with SetLogger, or slog.Default() if there is none
+func Logger() *slog.Logger {
+	if l := customLogger.Load(); l != nil {
+		return l
+	}
+	return slog.Default()
+}
+
+func logger() *slog.Logger {
+	return Logger()
+}
+
+// redactURL hides passwords and template variable values, which can be secrets, in urls that are logged
+// or returned in errors
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "(unparsable url)"
+	}
+	values := u.Query()
+	for k := range values {
+		if strings.HasPrefix(k, "var-") {
+			values[k] = []string{"xxxxx"}
+		}
+	}
+	u.RawQuery = values.Encode()
+	return u.Redacted()
+}
+
+// redactErr redacts the url of errors returned by http.Client
+func redactErr(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		urlErr.URL = redactURL(urlErr.URL)
+	}
+	return err
+}
@@ -0,0 +1,143 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ClientOptions configures how a Client connects to Grafana.
+// The zero value connects directly, without a proxy, and verifies certificates against the system roots.
+type ClientOptions struct {
+	// HTTPClient is used for all requests if set, and the other options are ignored.
+	// Share one between clients to reuse connections across reports.
+	HTTPClient *http.Client
+	// Transport is used for all requests if set. The proxy and TLS options are ignored.
+	Transport http.RoundTripper
+	// Timeout limits the time taken by each request. 0 means no timeout.
+	Timeout time.Duration
+
+	// ProxyURL is the proxy used for all requests
+	ProxyURL string
+	// ProxyFromEnvironment uses the proxy of the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables
+	// if ProxyURL is empty. By default, the environment is ignored.
+	ProxyFromEnvironment bool
+	// CAFile is a PEM bundle of certificate authorities trusted in addition to the system roots
+	CAFile string
+	// CertFile and KeyFile are the PEM client certificate and key used for mutual TLS
+	CertFile string
+	KeyFile  string
+	// InsecureSkipVerify disables verification of the Grafana server certificate
+	InsecureSkipVerify bool
+
+	// Auth adds credentials to every request, after the bearer token if one is given to the constructor.
+	// See BasicAuth, SessionCookie, OrgID, AuthProxy, JWT and Combine.
+	Auth Authenticator
+
+	// OrgID selects the Grafana organisation of the dashboards. It is sent in the X-Grafana-Org-Id header,
+	// and as the orgId parameter of render requests to Grafana 7 and later. 0 uses the default organisation of the user.
+	OrgID int
+	// Scale is the factor by which rendered panel images have more pixels than the default, e.g. PrintScale.
+	// Grafana 7 and later are passed it as the device scale factor, and render the same panel more sharply.
+	// Older versions do not support a scale, and are requested to render panels Scale times wider and higher instead:
+	// text and lines come out Scale times thinner and smaller once the panel is fitted to the report,
+	// and graphs may show more axis ticks. Leave it 0 for such versions if that matters.
+	// The size of panels in reports does not change. 0 renders the default number of pixels.
+	Scale float64
+}
+
+// Render scales for ClientOptions.Scale
+const (
+	// ScreenScale renders panels for reading reports on screen, at about 150 DPI for full width panels
+	ScreenScale = 1.0
+	// PrintScale renders panels for printing reports, at about 300 DPI for full width panels
+	PrintScale = 2.0
+)
+
+// screenDPI is the resolution of a full width panel rendered at ScreenScale, 1000 pixels across the 6.5in wide text of the default template
+const screenDPI = 150
+
+// ScaleForDPI returns the Scale rendering full width panels at dpi.
+// See ClientOptions.Scale for how versions before Grafana 7 apply it.
+func ScaleForDPI(dpi float64) float64 {
+	return dpi / screenDPI
+}
+
+func (o ClientOptions) authenticator(apiToken string) Authenticator {
+	var token Authenticator
+	if apiToken != "" {
+		token = BearerToken(apiToken)
+	}
+	var org Authenticator
+	if o.OrgID > 0 {
+		org = OrgID(o.OrgID)
+	}
+	return Combine(token, o.Auth, org)
+}
+
+func (o ClientOptions) httpClient() (*http.Client, error) {
+	if o.HTTPClient != nil {
+		return o.HTTPClient, nil
+	}
+	if o.Transport != nil {
+		return &http.Client{Transport: o.Transport, Timeout: o.Timeout}, nil
+	}
+
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.Proxy = nil
+	if o.ProxyFromEnvironment {
+		tr.Proxy = http.ProxyFromEnvironment
+	}
+	if o.ProxyURL != "" {
+		proxyURL, err := url.Parse(o.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("parsing proxy url %v: %w", o.ProxyURL, err)
+		}
+		tr.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: o.InsecureSkipVerify}
+	if o.CAFile != "" {
+		pem, err := ioutil.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA file %v: %w", o.CAFile, err)
+		}
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %v", o.CAFile)
+		}
+		tr.TLSClientConfig.RootCAs = roots
+	}
+	if o.CertFile != "" || o.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate %v: %w", o.CertFile, err)
+		}
+		tr.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return &http.Client{Transport: tr, Timeout: o.Timeout}, nil
+}
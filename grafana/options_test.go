@@ -0,0 +1,138 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+type countingTransport struct {
+	next     http.RoundTripper
+	requests int
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests++
+	return t.next.RoundTrip(req)
+}
+
+func TestClientOptions(t *testing.T) {
+	convey.Convey("When creating a client with options", t, func(c convey.C) {
+		ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintln(w, `{"Dashboard":{"Title":"Dash"}}`)
+		}))
+		defer ts.Close()
+
+		dir, err := ioutil.TempDir("", "clientoptions")
+		c.So(err, convey.ShouldBeNil)
+		defer os.RemoveAll(dir)
+		caFile := filepath.Join(dir, "ca.pem")
+		caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+		c.So(ioutil.WriteFile(caFile, caPEM, 0666), convey.ShouldBeNil)
+
+		c.Convey("It should trust the certificate authorities of the CA file", func(c convey.C) {
+			grf, err := NewV5ClientWithOptions(ts.URL, "", url.Values{}, false, ClientOptions{CAFile: caFile})
+			c.So(err, convey.ShouldBeNil)
+			dash, err := grf.GetDashboard(context.Background(), "testDash")
+			c.So(err, convey.ShouldBeNil)
+			c.So(dash.Title, convey.ShouldEqual, "Dash")
+		})
+
+		c.Convey("It should verify certificates by default", func(c convey.C) {
+			grf, err := NewV5ClientWithOptions(ts.URL, "", url.Values{}, false, ClientOptions{})
+			c.So(err, convey.ShouldBeNil)
+			_, err = grf.GetDashboard(context.Background(), "testDash")
+			c.So(err, convey.ShouldNotBeNil)
+		})
+
+		c.Convey("It should skip verification if asked to", func(c convey.C) {
+			grf, err := NewV5ClientWithOptions(ts.URL, "", url.Values{}, false, ClientOptions{InsecureSkipVerify: true})
+			c.So(err, convey.ShouldBeNil)
+			_, err = grf.GetDashboard(context.Background(), "testDash")
+			c.So(err, convey.ShouldBeNil)
+		})
+
+		c.Convey("It should use the shared http client", func(c convey.C) {
+			tr := &countingTransport{next: ts.Client().Transport}
+			grf, err := NewV5ClientWithOptions(ts.URL, "", url.Values{}, false, ClientOptions{HTTPClient: &http.Client{Transport: tr}})
+			c.So(err, convey.ShouldBeNil)
+			_, err = grf.GetDashboard(context.Background(), "testDash")
+			c.So(err, convey.ShouldBeNil)
+			_, _, err = grf.RenderPanel(context.Background(), Panel{Id: 1}, "testDash", TimeRange{"now-1h", "now"}, RenderOptions{})
+			c.So(err, convey.ShouldBeNil)
+			c.So(tr.requests, convey.ShouldEqual, 2)
+		})
+
+		c.Convey("It should use the custom transport", func(c convey.C) {
+			tr := &countingTransport{next: ts.Client().Transport}
+			grf, err := NewV4ClientWithOptions(ts.URL, "", url.Values{}, false, ClientOptions{Transport: tr})
+			c.So(err, convey.ShouldBeNil)
+			_, err = grf.GetDashboard(context.Background(), "testDash")
+			c.So(err, convey.ShouldBeNil)
+			c.So(tr.requests, convey.ShouldEqual, 1)
+		})
+
+		c.Convey("It should send requests through the proxy", func(c convey.C) {
+			proxied := ""
+			proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				proxied = r.URL.String()
+				fmt.Fprintln(w, `{"Dashboard":{"Title":"Proxied"}}`)
+			}))
+			defer proxy.Close()
+
+			grf, err := NewV5ClientWithOptions("http://grafana.invalid", "", url.Values{}, false, ClientOptions{ProxyURL: proxy.URL})
+			c.So(err, convey.ShouldBeNil)
+			dash, err := grf.GetDashboard(context.Background(), "testDash")
+			c.So(err, convey.ShouldBeNil)
+			c.So(dash.Title, convey.ShouldEqual, "Proxied")
+			c.So(proxied, convey.ShouldEqual, "http://grafana.invalid/api/dashboards/uid/testDash")
+		})
+
+		c.Convey("It should only use the proxy of the environment if asked to", func(c convey.C) {
+			httpClient, err := ClientOptions{}.httpClient()
+			c.So(err, convey.ShouldBeNil)
+			c.So(httpClient.Transport.(*http.Transport).Proxy, convey.ShouldBeNil)
+
+			httpClient, err = ClientOptions{ProxyFromEnvironment: true}.httpClient()
+			c.So(err, convey.ShouldBeNil)
+			c.So(httpClient.Transport.(*http.Transport).Proxy, convey.ShouldNotBeNil)
+		})
+
+		c.Convey("It should fail on invalid options", func(c convey.C) {
+			_, err := NewV5ClientWithOptions(ts.URL, "", url.Values{}, false, ClientOptions{CAFile: filepath.Join(dir, "missing.pem")})
+			c.So(err, convey.ShouldNotBeNil)
+
+			c.So(ioutil.WriteFile(filepath.Join(dir, "empty.pem"), []byte("not a certificate"), 0666), convey.ShouldBeNil)
+			_, err = NewV5ClientWithOptions(ts.URL, "", url.Values{}, false, ClientOptions{CAFile: filepath.Join(dir, "empty.pem")})
+			c.So(err, convey.ShouldNotBeNil)
+
+			_, err = NewV5ClientWithOptions(ts.URL, "", url.Values{}, false, ClientOptions{CertFile: caFile, KeyFile: filepath.Join(dir, "missing.key")})
+			c.So(err, convey.ShouldNotBeNil)
+		})
+	})
+}
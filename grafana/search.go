@@ -0,0 +1,128 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/mlesar/grafana-report/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SearchQuery selects dashboards through the Grafana search API.
+// The zero value selects all dashboards the user can see.
+type SearchQuery struct {
+	// Query matches dashboards whose title contains it, ignoring case
+	Query string
+	// Tags matches dashboards having all of the tags
+	Tags []string
+	// FolderUIDs matches dashboards in any of the folders. Supported by Grafana 8 and later.
+	FolderUIDs []string
+	// FolderIDs matches dashboards in any of the folders. Deprecated by Grafana in favour of FolderUIDs.
+	FolderIDs []int
+	// Limit is the maximum number of dashboards returned. 0 uses the Grafana default of 1000.
+	Limit int
+}
+
+func (q SearchQuery) values() url.Values {
+	values := url.Values{}
+	values.Set("type", "dash-db")
+	if q.Query != "" {
+		values.Set("query", q.Query)
+	}
+	for _, t := range q.Tags {
+		values.Add("tag", t)
+	}
+	for _, f := range q.FolderUIDs {
+		values.Add("folderUIDs", f)
+	}
+	for _, f := range q.FolderIDs {
+		values.Add("folderIds", strconv.Itoa(f))
+	}
+	if q.Limit > 0 {
+		values.Set("limit", strconv.Itoa(q.Limit))
+	}
+	return values
+}
+
+// DashboardRef is a dashboard found by a search
+type DashboardRef struct {
+	// Name identifies the dashboard to the Client that found it, i.e. its slug on Grafana 4 and its uid otherwise
+	Name        string
+	UID         string
+	Title       string
+	URI         string
+	URL         string
+	Tags        []string
+	FolderID    int
+	FolderUID   string
+	FolderTitle string
+}
+
+// SearchDashboards returns the dashboards matching q
+func (g client) SearchDashboards(ctx context.Context, q SearchQuery) (refs []DashboardRef, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "grafana.SearchDashboards", trace.WithAttributes(attribute.String("query", q.Query)))
+	defer func() { tracing.End(span, err) }()
+
+	searchURL := g.url + "/api/search?" + q.values().Encode()
+	logger().Debug("searching dashboards", "url", redactURL(searchURL))
+	body, err := g.get(ctx, "searchDashboards", searchURL)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(body, &refs); err != nil {
+		return nil, fmt.Errorf("error parsing searchDashboards response from %v: %v", redactURL(searchURL), err)
+	}
+	for i, r := range refs {
+		if g.slugNames {
+			refs[i].Name = strings.TrimPrefix(r.URI, "db/")
+		} else {
+			refs[i].Name = r.UID
+		}
+	}
+	return refs, nil
+}
+
+// ResolveTitle returns the name c identifies the dashboard titled title by, i.e. its uid on Grafana 5 and later.
+// Titles are matched exactly. It is an error if no dashboard or more than one dashboard has the title;
+// use SearchDashboards with FolderUIDs to choose between dashboards of the same title in different folders.
+func ResolveTitle(ctx context.Context, c Client, title string) (string, error) {
+	refs, err := c.SearchDashboards(ctx, SearchQuery{Query: title})
+	if err != nil {
+		return "", err
+	}
+	var names []string
+	for _, r := range refs {
+		if r.Title == title {
+			names = append(names, r.Name)
+		}
+	}
+	switch len(names) {
+	case 0:
+		return "", fmt.Errorf("no dashboard titled %q found", title)
+	case 1:
+		return names[0], nil
+	default:
+		return "", fmt.Errorf("%d dashboards titled %q found: %v", len(names), title, strings.Join(names, ", "))
+	}
+}
@@ -0,0 +1,92 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+const searchJSON = `[
+	{"id":1,"uid":"abc","title":"Weekly","uri":"db/weekly","url":"/d/abc/weekly","type":"dash-db","tags":["weekly-report"],"folderUid":"f1","folderTitle":"Ops"},
+	{"id":2,"uid":"def","title":"Weekly","uri":"db/weekly-2","url":"/d/def/weekly-2","type":"dash-db","tags":["weekly-report"],"folderUid":"f2","folderTitle":"Dev"},
+	{"id":3,"uid":"ghi","title":"Weekly summary","uri":"db/weekly-summary","url":"/d/ghi/weekly-summary","type":"dash-db","tags":[]}
+]`
+
+func TestSearchDashboards(t *testing.T) {
+	convey.Convey("When searching dashboards", t, func(c convey.C) {
+		var query url.Values
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			query = r.URL.Query()
+			fmt.Fprint(w, searchJSON)
+		}))
+		defer ts.Close()
+
+		grf := NewV5Client(ts.URL, "", url.Values{}, true, false)
+
+		c.Convey("It should pass the query, tags and folders to the search API", func(c convey.C) {
+			_, err := grf.SearchDashboards(context.Background(), SearchQuery{Query: "Weekly", Tags: []string{"weekly-report", "ops"}, FolderUIDs: []string{"f1"}, FolderIDs: []int{4}, Limit: 10})
+			c.So(err, convey.ShouldBeNil)
+			c.So(query.Get("type"), convey.ShouldEqual, "dash-db")
+			c.So(query.Get("query"), convey.ShouldEqual, "Weekly")
+			c.So(query["tag"], convey.ShouldResemble, []string{"weekly-report", "ops"})
+			c.So(query.Get("folderUIDs"), convey.ShouldEqual, "f1")
+			c.So(query.Get("folderIds"), convey.ShouldEqual, "4")
+			c.So(query.Get("limit"), convey.ShouldEqual, "10")
+		})
+
+		c.Convey("It should name dashboards by uid", func(c convey.C) {
+			refs, err := grf.SearchDashboards(context.Background(), SearchQuery{})
+			c.So(err, convey.ShouldBeNil)
+			c.So(refs, convey.ShouldHaveLength, 3)
+			c.So(refs[0].Name, convey.ShouldEqual, "abc")
+			c.So(refs[0].Tags, convey.ShouldResemble, []string{"weekly-report"})
+			c.So(refs[0].FolderTitle, convey.ShouldEqual, "Ops")
+		})
+
+		c.Convey("A Grafana 4 client should name dashboards by slug", func(c convey.C) {
+			refs, err := NewV4Client(ts.URL, "", url.Values{}, true, false).SearchDashboards(context.Background(), SearchQuery{})
+			c.So(err, convey.ShouldBeNil)
+			c.So(refs[0].Name, convey.ShouldEqual, "weekly")
+		})
+
+		c.Convey("Caching clients should search through the wrapped client", func(c convey.C) {
+			refs, err := NewCachingClient(grf, NewMemoryPanelCache(1, 0)).SearchDashboards(context.Background(), SearchQuery{})
+			c.So(err, convey.ShouldBeNil)
+			c.So(refs, convey.ShouldHaveLength, 3)
+		})
+
+		c.Convey("It should resolve a unique title", func(c convey.C) {
+			name, err := ResolveTitle(context.Background(), grf, "Weekly summary")
+			c.So(err, convey.ShouldBeNil)
+			c.So(name, convey.ShouldEqual, "ghi")
+		})
+
+		c.Convey("It should not resolve an ambiguous or unknown title", func(c convey.C) {
+			_, err := ResolveTitle(context.Background(), grf, "Weekly")
+			c.So(err, convey.ShouldNotBeNil)
+			_, err = ResolveTitle(context.Background(), grf, "Monthly")
+			c.So(err, convey.ShouldNotBeNil)
+		})
+	})
+}
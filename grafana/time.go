@@ -17,10 +17,9 @@
 package grafana
 
 import (
-	"io/ioutil"
-	"log"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -54,10 +53,6 @@ const (
 	boundaryTimeRegExp = "^(.*?)/([dwMy])$"
 )
 
-func init() {
-	log.SetOutput(ioutil.Discard)
-}
-
 func NewTimeRange(from, to string) TimeRange {
 	if from == "" {
 		from = "now-1h"
@@ -68,6 +63,15 @@ func NewTimeRange(from, to string) TimeRange {
 	return TimeRange{from, to}
 }
 
+// Override returns the time range displayed by a panel with Grafana's per-panel
+// time overrides applied. timeFrom, e.g. "2h" or "now/d", replaces a relative range
+// with "now-2h" or "now/d" to "now"; absolute ranges are kept as is, matching Grafana.
+// timeShift, e.g. "1d" or "1d/d", moves the range back in time and makes it absolute.
+// Overrides that are not recognised time specifications are ignored.
+func (tr TimeRange) Override(timeFrom, timeShift string) TimeRange {
+	return newNow().override(tr, timeFrom, timeShift)
+}
+
 // Formats Grafana 'From' time spec into absolute printable time
 func (tr TimeRange) FromFormatted() string {
 	n := newNow()
@@ -80,6 +84,16 @@ func (tr TimeRange) ToFormatted() string {
 	return n.parseTo(tr.To).Format(time.UnixDate)
 }
 
+// FromTime returns the absolute time of the Grafana 'From' time spec
+func (tr TimeRange) FromTime() time.Time {
+	return newNow().parseFrom(tr.From)
+}
+
+// ToTime returns the absolute time of the Grafana 'To' time spec
+func (tr TimeRange) ToTime() time.Time {
+	return newNow().parseTo(tr.To)
+}
+
 func newNow() now {
 	return now(time.Now())
 }
@@ -88,6 +102,29 @@ func (n now) asTime() time.Time {
 	return time.Time(n)
 }
 
+func (n now) override(tr TimeRange, timeFrom, timeShift string) TimeRange {
+	if timeFrom != "" && !isAbsTime(tr.From) {
+		if !strings.HasPrefix(timeFrom, "now") {
+			timeFrom = "now-" + timeFrom
+		}
+		if isTimeSpec(timeFrom) {
+			tr = TimeRange{timeFrom, "now"}
+		}
+	}
+
+	if timeShift != "" && isTimeSpec("now-"+timeShift) {
+		from, to := n.parseFrom(tr.From), n.parseTo(tr.To)
+		if isHumanFriendlyBoundray(timeShift) {
+			//'To' boundaries are exclusive, round from the last moment inside the range
+			to = to.Add(-time.Millisecond)
+		}
+		from = now(from).parseFrom("now-" + timeShift)
+		to = now(to).parseTo("now-" + timeShift)
+		tr = TimeRange{formatAbsTime(from), formatAbsTime(to)}
+	}
+	return tr
+}
+
 func (n now) parseFrom(s string) time.Time {
 	return n.parseHumanFriendlyBoundary(s, From)
 }
@@ -204,6 +241,10 @@ func parseAbsTime(s string) time.Time {
 	panic(unrecognized(s))
 }
 
+func formatAbsTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+}
+
 func unrecognized(s string) string {
 	return s + " is not a recognised time format"
 }
@@ -223,3 +264,16 @@ func isHumanFriendlyBoundray(s string) bool {
 	matched, _ := regexp.MatchString(boundaryTimeRegExp, s)
 	return matched
 }
+
+func isAbsTime(s string) bool {
+	_, err := strconv.ParseInt(s, 10, 64)
+	return err == nil
+}
+
+// isTimeSpec reports whether s can be parsed without panicking
+func isTimeSpec(s string) bool {
+	if isHumanFriendlyBoundray(s) {
+		s = regexp.MustCompile(boundaryTimeRegExp).FindStringSubmatch(s)[1]
+	}
+	return s == "now" || isRelativeTime(s) || isAbsTime(s)
+}
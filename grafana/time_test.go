@@ -202,4 +202,52 @@ func TestTimeParsing(tst *testing.T) {
 		})
 
 	})
+
+	convey.Convey("When applying panel time overrides", tst, func(c convey.C) {
+		//now = Wed, 06 Jan 2016 16:34:32 UTC
+		relative := TimeRange{"now-6h", "now"}
+		absolute := TimeRange{"1451865600000", "1451952000000"} //Mon, 04 Jan 2016 00:00:00 UTC to Tue, 05 Jan 2016 00:00:00 UTC
+
+		c.Convey("No overrides should return the dashboard time range", func(c convey.C) {
+			c.So(t.override(relative, "", ""), convey.ShouldResemble, relative)
+			c.So(t.override(absolute, "", ""), convey.ShouldResemble, absolute)
+		})
+
+		c.Convey("timeFrom should replace a relative range", func(c convey.C) {
+			c.So(t.override(relative, "2h", ""), convey.ShouldResemble, TimeRange{"now-2h", "now"})
+			c.So(t.override(relative, "now/d", ""), convey.ShouldResemble, TimeRange{"now/d", "now"})
+		})
+
+		c.Convey("timeFrom should not change an absolute range", func(c convey.C) {
+			c.So(t.override(absolute, "2h", ""), convey.ShouldResemble, absolute)
+		})
+
+		c.Convey("timeShift should move the range back in time", func(c convey.C) {
+			shifted := t.override(absolute, "", "1d")
+			c.So(t.parseFrom(shifted.From), sameTimeAs, time.Unix(1451779200, 0))
+			c.So(t.parseTo(shifted.To), sameTimeAs, time.Unix(1451865600, 0))
+
+			shifted = t.override(relative, "", "1h")
+			c.So(t.parseFrom(shifted.From).UTC(), sameTimeAs, testNow.Add(-7*time.Hour))
+			c.So(t.parseTo(shifted.To).UTC(), sameTimeAs, testNow.Add(-1*time.Hour))
+		})
+
+		c.Convey("timeShift should support boundaries", func(c convey.C) {
+			yesterday := t.override(TimeRange{"now/d", "now/d"}, "", "1d/d")
+			startOfYesterday, _ := time.Parse(time.RFC1123, "Tue, 05 Jan 2016 00:00:00 UTC")
+			endOfYesterday, _ := time.Parse(time.RFC1123, "Wed, 06 Jan 2016 00:00:00 UTC")
+			c.So(t.parseFrom(yesterday.From).Equal(startOfYesterday), convey.ShouldBeTrue)
+			c.So(t.parseTo(yesterday.To).Equal(endOfYesterday), convey.ShouldBeTrue)
+		})
+
+		c.Convey("timeFrom and timeShift should combine", func(c convey.C) {
+			tr := t.override(relative, "2h", "1d")
+			c.So(t.parseFrom(tr.From).UTC(), sameTimeAs, testNow.Add(-2*time.Hour).AddDate(0, 0, -1))
+			c.So(t.parseTo(tr.To).UTC(), sameTimeAs, testNow.AddDate(0, 0, -1))
+		})
+
+		c.Convey("Unrecognised overrides should be ignored", func(c convey.C) {
+			c.So(t.override(relative, "$interval", "2k"), convey.ShouldResemble, relative)
+		})
+	})
 }
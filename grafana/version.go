@@ -0,0 +1,105 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// NewClient detects the version of the Grafana server at grafanaURL and creates a Client using its endpoints.
+// Grafana 4 is addressed by dashboard slug, later versions by dashboard uid.
+// Grafana 7 and later render panels in the organisation set in opts.
+// If apiToken is the empty string, authorization headers will be omitted from requests.
+// variables are Grafana template variable url values of the form var-{name}={value}, e.g. var-host=dev
+func NewClient(ctx context.Context, grafanaURL string, apiToken string, variables url.Values, gridLayout bool, opts ClientOptions) (Client, error) {
+	httpClient, err := opts.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	auth := opts.authenticator(apiToken)
+
+	version, err := detectVersion(ctx, client{url: grafanaURL, auth: auth, httpClient: httpClient})
+	if err != nil {
+		return nil, err
+	}
+	major, err := majorVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	logger().Debug("detected Grafana version", "url", redactURL(grafanaURL), "version", version)
+
+	switch {
+	case major < 5:
+		return newV4Client(grafanaURL, variables, gridLayout, httpClient, auth, opts), nil
+	case major < 7:
+		return newV5Client(grafanaURL, variables, gridLayout, httpClient, auth, opts), nil
+	default:
+		return newModernClient(grafanaURL, variables, gridLayout, httpClient, auth, opts), nil
+	}
+}
+
+// DetectVersion returns the version of the Grafana server at grafanaURL, e.g. 10.4.1
+func DetectVersion(ctx context.Context, grafanaURL string, apiToken string, opts ClientOptions) (string, error) {
+	httpClient, err := opts.httpClient()
+	if err != nil {
+		return "", err
+	}
+	return detectVersion(ctx, client{url: grafanaURL, auth: opts.authenticator(apiToken), httpClient: httpClient})
+}
+
+func detectVersion(ctx context.Context, g client) (string, error) {
+	//the health endpoint needs no authentication, but is missing from old versions
+	var health struct {
+		Version string
+	}
+	body, healthErr := g.get(ctx, "getHealth", g.url+"/api/health")
+	if healthErr == nil && json.Unmarshal(body, &health) == nil && health.Version != "" {
+		return health.Version, nil
+	}
+
+	var settings struct {
+		BuildInfo struct {
+			Version string
+		}
+	}
+	body, err := g.get(ctx, "getFrontendSettings", g.url+"/api/frontend/settings")
+	if err != nil {
+		return "", fmt.Errorf("error detecting Grafana version: %v", err)
+	}
+	if err = json.Unmarshal(body, &settings); err != nil {
+		return "", fmt.Errorf("error parsing getFrontendSettings response from %v: %v", redactURL(g.url), err)
+	}
+	if settings.BuildInfo.Version == "" {
+		return "", fmt.Errorf("error detecting Grafana version: no version reported by %v", redactURL(g.url))
+	}
+	return settings.BuildInfo.Version, nil
+}
+
+// majorVersion parses the major version of versions like 10.4.1, v9.5.0 or 11.0.0-preview
+func majorVersion(version string) (int, error) {
+	major := strings.SplitN(strings.TrimPrefix(version, "v"), ".", 2)[0]
+	n, err := strconv.Atoi(major)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing Grafana version %q: %v", version, err)
+	}
+	return n, nil
+}
@@ -0,0 +1,111 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+// versionServer serves a Grafana reporting version on the health endpoint, or only on the frontend settings endpoint if noHealth is set
+func versionServer(version string, noHealth bool, requestURI *string, requestHeaders *http.Header) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/health":
+			if noHealth {
+				http.NotFound(w, r)
+				return
+			}
+			fmt.Fprintf(w, `{"commit":"abc","database":"ok","version":"%s"}`, version)
+		case "/api/frontend/settings":
+			fmt.Fprintf(w, `{"buildInfo":{"version":"%s","commit":"abc"}}`, version)
+		default:
+			*requestURI = r.RequestURI
+			*requestHeaders = r.Header
+		}
+	}))
+}
+
+func TestNewClient(t *testing.T) {
+	convey.Convey("When creating a client for a Grafana server of unknown version", t, func(c convey.C) {
+		requestURI := ""
+		requestHeaders := http.Header{}
+		panel := Panel{Id: 44, Type: "graph", Title: "title"}
+		opts := ClientOptions{OrgID: 2, Scale: 2}
+
+		cases := map[string]struct {
+			version     string
+			noHealth    bool
+			pngEndpoint string
+			modern      bool
+		}{
+			"4.6.3":          {"4.6.3", true, "/render/dashboard-solo/db/testDash", false},
+			"5.4.0":          {"5.4.0", false, "/render/d-solo/testDash/_", false},
+			"6.7.4":          {"6.7.4", true, "/render/d-solo/testDash/_", false},
+			"8.5.27":         {"8.5.27", false, "/render/d-solo/testDash/_", true},
+			"11.0.0-preview": {"11.0.0-preview", false, "/render/d-solo/testDash/_", true},
+			"v10 (settings)": {"v10.4.1", true, "/render/d-solo/testDash/_", true},
+		}
+		for desc, tc := range cases {
+			ts := versionServer(tc.version, tc.noHealth, &requestURI, &requestHeaders)
+			defer ts.Close()
+
+			grf, err := NewClient(context.Background(), ts.URL, "1234", url.Values{}, false, opts)
+			c.So(err, convey.ShouldBeNil)
+			grf.RenderPanel(context.Background(), panel, "testDash", TimeRange{"now-1h", "now"}, RenderOptions{})
+
+			c.Convey(fmt.Sprintf("Grafana %s should be rendered through its render endpoint", desc), func(c convey.C) {
+				c.So(requestURI, convey.ShouldStartWith, tc.pngEndpoint)
+				c.So(requestHeaders.Get("X-Grafana-Org-Id"), convey.ShouldEqual, "2")
+			})
+
+			c.Convey(fmt.Sprintf("Grafana %s should only be passed orgId and scale if it is 7 or later", desc), func(c convey.C) {
+				if tc.modern {
+					c.So(requestURI, convey.ShouldContainSubstring, "orgId=2")
+					c.So(requestURI, convey.ShouldContainSubstring, "scale=2")
+				} else {
+					c.So(requestURI, convey.ShouldNotContainSubstring, "orgId=")
+					c.So(requestURI, convey.ShouldNotContainSubstring, "scale=")
+				}
+			})
+		}
+
+		c.Convey("It should fail if the version cannot be detected", func(c convey.C) {
+			ts := httptest.NewServer(http.NotFoundHandler())
+			defer ts.Close()
+			_, err := NewClient(context.Background(), ts.URL, "", url.Values{}, false, ClientOptions{})
+			c.So(err, convey.ShouldNotBeNil)
+		})
+	})
+
+	convey.Convey("When detecting the Grafana version", t, func(c convey.C) {
+		requestURI := ""
+		requestHeaders := http.Header{}
+		ts := versionServer("10.4.1", false, &requestURI, &requestHeaders)
+		defer ts.Close()
+
+		version, err := DetectVersion(context.Background(), ts.URL, "", ClientOptions{})
+		c.So(err, convey.ShouldBeNil)
+		c.So(version, convey.ShouldEqual, "10.4.1")
+	})
+}
@@ -0,0 +1,157 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const reportLog = "report.log"
+
+// LaTeXError is the first error pdflatex reports in the log of a report
+type LaTeXError struct {
+	// Message is the LaTeX error message, e.g. Undefined control sequence
+	Message string
+	// Line is the line of the error in report.tex, or 0 if unknown or the error is in another file
+	Line int
+	// File is the file, e.g. a package, of errors outside report.tex
+	File string
+	// Input is the input LaTeX read up to the error, e.g. \foo
+	Input string
+	// Source is the lines of report.tex around Line, numbered, with the error line marked by >
+	Source string
+	// Hint suggests how to fix missing packages and files
+	Hint string
+}
+
+func (e *LaTeXError) Error() string {
+	msg := e.Message
+	if e.Line > 0 {
+		msg = fmt.Sprintf("%s at line %d", msg, e.Line)
+	}
+	if e.File != "" {
+		msg = fmt.Sprintf("%s in %s", msg, e.File)
+	}
+	if e.Input != "" {
+		msg = fmt.Sprintf("%s: %s", msg, e.Input)
+	}
+	if e.Hint != "" {
+		msg = fmt.Sprintf("%s (%s)", msg, e.Hint)
+	}
+	return msg
+}
+
+var (
+	// latexErrorLine matches error lines, in the default format and the -file-line-error format runLaTeX uses,
+	// which names the file and line of the error, e.g. ./report.tex:42: Undefined control sequence.
+	latexErrorLine = regexp.MustCompile(`^(?:! |(\S+\.\w+):(\d+): )(.*)$`)
+	// latexInputLine matches the line number in the current file and the input read up to the error, e.g. l.42 \foo
+	latexInputLine   = regexp.MustCompile(`^l\.(\d+) (.*)$`)
+	latexFileMissing = regexp.MustCompile("File `([^']+)' not found")
+)
+
+// sourceContext is the number of report.tex lines shown before and after the error line
+const sourceContext = 2
+
+// readLaTeXError reads the first LaTeX error from the report.log in dir. It returns nil if there is none.
+func readLaTeXError(dir string) *LaTeXError {
+	log, err := ioutil.ReadFile(filepath.Join(dir, reportLog))
+	if err != nil {
+		return nil
+	}
+	tex, _ := ioutil.ReadFile(filepath.Join(dir, reportTexFile))
+	return parseLaTeXLog(string(log), string(tex))
+}
+
+// parseLaTeXLog extracts the first error from the pdflatex log of tex. It returns nil if there is none.
+func parseLaTeXLog(log string, tex string) *LaTeXError {
+	lines := strings.Split(log, "\n")
+	for i, l := range lines {
+		m := latexErrorLine.FindStringSubmatch(strings.TrimRight(l, "\r"))
+		if m == nil {
+			continue
+		}
+		e := &LaTeXError{Message: strings.TrimSuffix(strings.TrimSpace(m[3]), ".")}
+		//the l. line numbers the current file, which is only known to be report.tex in the -file-line-error format.
+		//Errors in the default format are assumed to be in report.tex.
+		inReport := m[1] == "" || strings.TrimPrefix(m[1], "./") == reportTexFile
+		if !inReport {
+			e.File = m[1]
+		} else {
+			e.Line, _ = strconv.Atoi(m[2])
+		}
+		for _, next := range lines[i+1:] {
+			if im := latexInputLine.FindStringSubmatch(strings.TrimRight(next, "\r")); im != nil {
+				if inReport {
+					e.Line, _ = strconv.Atoi(im[1])
+				}
+				e.Input = strings.TrimSpace(im[2])
+				break
+			}
+		}
+		if e.Input == "" && e.Line > 0 {
+			e.Input = line(tex, e.Line)
+		}
+		e.Source = sourceAround(tex, e.Line)
+		e.Hint = hint(e.Message)
+		return e
+	}
+	return nil
+}
+
+// sourceAround returns the numbered lines of tex around line n, marking line n
+func sourceAround(tex string, n int) string {
+	lines := strings.Split(tex, "\n")
+	if n < 1 || n > len(lines) {
+		return ""
+	}
+	var b strings.Builder
+	for i := n - sourceContext; i <= n+sourceContext; i++ {
+		if i < 1 || i > len(lines) {
+			continue
+		}
+		marker := " "
+		if i == n {
+			marker = ">"
+		}
+		fmt.Fprintf(&b, "%s%4d | %s\n", marker, i, lines[i-1])
+	}
+	return b.String()
+}
+
+// hint suggests how to fix the missing packages and files LaTeX reports in msg
+func hint(msg string) string {
+	m := latexFileMissing.FindStringSubmatch(msg)
+	if m == nil {
+		return ""
+	}
+	file := m[1]
+	switch filepath.Ext(file) {
+	case ".sty", ".cls":
+		pkg := strings.TrimSuffix(file, filepath.Ext(file))
+		return fmt.Sprintf("the LaTeX package %s is not installed, install it e.g. with tlmgr install %s", pkg, pkg)
+	}
+	if strings.HasPrefix(filepath.Base(file), "image") {
+		return fmt.Sprintf("the panel image %s is missing, check the panel ids the template uses", file)
+	}
+	return fmt.Sprintf("the file %s is missing", file)
+}
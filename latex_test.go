@@ -0,0 +1,188 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/mlesar/grafana-report/grafana"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+const undefinedLog = `This is pdfTeX, Version 3.14159265-2.6-1.40.20 (TeX Live 2019) (preloaded format=pdflatex)
+ restricted \write18 enabled.
+entering extended mode
+(./report.tex
+LaTeX2e <2020-02-02> patch level 2
+! Undefined control sequence.
+l.4 \foo
+        {bar}
+Here is how much of TeX's memory you used:
+`
+
+const missingPackageLog = `(./report.tex
+LaTeX2e <2020-02-02> patch level 2
+
+! LaTeX Error: File ` + "`fancyhdr.sty'" + ` not found.
+
+Type X to quit or <RETURN> to proceed,
+or enter new name. (Default extension: sty)
+
+Enter file name:
+! Emergency stop.
+<read *>
+
+l.2 \usepackage
+               {fancyhdr}^^M
+`
+
+const missingImageLog = `! LaTeX Error: File ` + "`image7'" + ` not found.
+
+See the LaTeX manual or LaTeX Companion for explanation.
+Type  H <return>  for immediate help.
+ ...
+
+l.5 \includegraphics{image7}
+`
+
+const packageErrorLog = `(./report.tex
+(/usr/share/texmf/tex/latex/acme/acme.sty
+/usr/share/texmf/tex/latex/acme/acme.sty:12: Undefined control sequence.
+l.12 \acme@setup
+`
+
+const reportTex = `\documentclass{article}
+\usepackage{fancyhdr}
+\begin{document}
+\foo{bar}
+\includegraphics{image7}
+\end{document}`
+
+func TestLaTeXError(t *testing.T) {
+	convey.Convey("When parsing pdflatex logs", t, func(c convey.C) {
+		c.Convey("It should extract the first error, its line and input", func(c convey.C) {
+			e := parseLaTeXLog(undefinedLog, reportTex)
+			c.So(e, convey.ShouldNotBeNil)
+			c.So(e.Message, convey.ShouldEqual, "Undefined control sequence")
+			c.So(e.Line, convey.ShouldEqual, 4)
+			c.So(e.Input, convey.ShouldEqual, `\foo`)
+			c.So(e.Hint, convey.ShouldBeEmpty)
+			c.So(e.Error(), convey.ShouldEqual, `Undefined control sequence at line 4: \foo`)
+		})
+
+		c.Convey("It should show the source around the error", func(c convey.C) {
+			e := parseLaTeXLog(undefinedLog, reportTex)
+			c.So(e.Source, convey.ShouldEqual, "    2 | \\usepackage{fancyhdr}\n"+
+				"    3 | \\begin{document}\n"+
+				">   4 | \\foo{bar}\n"+
+				"    5 | \\includegraphics{image7}\n"+
+				"    6 | \\end{document}\n")
+		})
+
+		c.Convey("It should hint at missing packages", func(c convey.C) {
+			e := parseLaTeXLog(missingPackageLog, reportTex)
+			c.So(e.Message, convey.ShouldEqual, "LaTeX Error: File `fancyhdr.sty' not found")
+			c.So(e.Line, convey.ShouldEqual, 2)
+			c.So(e.Hint, convey.ShouldContainSubstring, "tlmgr install fancyhdr")
+		})
+
+		c.Convey("It should hint at missing panel images", func(c convey.C) {
+			e := parseLaTeXLog(missingImageLog, reportTex)
+			c.So(e.Line, convey.ShouldEqual, 5)
+			c.So(e.Hint, convey.ShouldContainSubstring, "panel image image7")
+		})
+
+		c.Convey("It should read errors in the file:line:error format", func(c convey.C) {
+			e := parseLaTeXLog("./report.tex:4: Undefined control sequence.\n", reportTex)
+			c.So(e.Line, convey.ShouldEqual, 4)
+			c.So(e.Input, convey.ShouldEqual, `\foo{bar}`)
+		})
+
+		c.Convey("It should not take the line of errors in other files for a line of report.tex", func(c convey.C) {
+			e := parseLaTeXLog(packageErrorLog, reportTex)
+			c.So(e.Line, convey.ShouldEqual, 0)
+			c.So(e.File, convey.ShouldEqual, "/usr/share/texmf/tex/latex/acme/acme.sty")
+			c.So(e.Input, convey.ShouldEqual, `\acme@setup`)
+			c.So(e.Source, convey.ShouldBeEmpty)
+			c.So(e.Error(), convey.ShouldEqual, `Undefined control sequence in /usr/share/texmf/tex/latex/acme/acme.sty: \acme@setup`)
+		})
+
+		c.Convey("It should return nil for logs without errors", func(c convey.C) {
+			c.So(parseLaTeXLog("Output written on report.pdf (1 page, 1234 bytes).\n", reportTex), convey.ShouldBeNil)
+		})
+	})
+
+	convey.Convey("When LaTeX fails to compile a report", t, func(c convey.C) {
+		path := os.Getenv("PATH")
+		defer os.Setenv("PATH", path)
+		binDir, _ := ioutil.TempDir("", "bin")
+		defer os.RemoveAll(binDir)
+		ioutil.WriteFile(filepath.Join(binDir, "pdflatex"), []byte(fakeLaTeX), 0777)
+		os.Setenv("PATH", binDir+string(os.PathListSeparator)+path)
+
+		dir, _ := ioutil.TempDir("", "report")
+		defer os.RemoveAll(dir)
+		os.MkdirAll(filepath.Join(dir, imgDir), 0777)
+		ioutil.WriteFile(filepath.Join(dir, imgDir, "image4.png"), nil, 0666)
+		ioutil.WriteFile(filepath.Join(dir, reportTexFile), []byte(reportTex), 0666)
+
+		_, err := runLaTeX(context.Background(), dir)
+
+		c.Convey("It should return a LaTeXError", func(c convey.C) {
+			var latexErr *LaTeXError
+			c.So(errors.As(err, &latexErr), convey.ShouldBeTrue)
+			c.So(latexErr.Line, convey.ShouldEqual, 4)
+			c.So(err.Error(), convey.ShouldEqual, `LaTeX preprocessing failed: Undefined control sequence at line 4: \foo`)
+		})
+	})
+}
+
+// TestDefaultTemplatesCompile compiles the default templates with every option that changes their preamble.
+// It needs a TeX installation, and is skipped without pdflatex.
+func TestDefaultTemplatesCompile(t *testing.T) {
+	if _, err := exec.LookPath("pdflatex"); err != nil {
+		t.Skip("pdflatex is not installed")
+	}
+	convey.Convey("When compiling the default templates", t, func(c convey.C) {
+		logoDir, err := ioutil.TempDir("", "logo")
+		c.So(err, convey.ShouldBeNil)
+		defer os.RemoveAll(logoDir)
+		c.So(writePlaceholders(logoDir, []grafana.Panel{{Id: 0}}), convey.ShouldBeNil)
+		logo := filepath.Join(logoDir, "image0.png")
+
+		dash := testDashboard()
+		for _, gridLayout := range []bool{false, true} {
+			c.Convey(fmt.Sprintf("They should compile with grid layout %v", gridLayout), func(c convey.C) {
+				rep, _, err := generateTestTeX(c, dash, Options{GridLayout: gridLayout, ListOfFigures: true, TableOfContents: true, PageBreaks: RowPerPage,
+					Branding: Branding{Logo: logo, Company: "ACME & Co", Header: "Weekly", Footer: "Internal", Watermark: "Draft"}})
+				defer rep.Clean()
+				c.So(err, convey.ShouldBeNil)
+				c.So(writePlaceholders(rep.imgDirPath(), dash.Panels), convey.ShouldBeNil)
+				pdf, err := runLaTeX(context.Background(), rep.tmpDir)
+				c.So(err, convey.ShouldBeNil)
+				pdf.Close()
+			})
+		}
+	})
+}
@@ -0,0 +1,228 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"sort"
+
+	"github.com/mlesar/grafana-report/grafana"
+)
+
+// gridColumns is the number of columns of the Grafana dashboard grid
+const gridColumns = 24
+
+// GridRow is a visual row of the dashboard grid: the panels that share a horizontal band of the dashboard.
+// Templates typeset a row as one line of minipages, so that LaTeX breaks pages between rows, not within panels.
+type GridRow struct {
+	Cells []GridCell
+	// Y and H are the top and the height of the row in grid units
+	Y, H float64
+	// PageBreak reports whether the row starts a new page
+	PageBreak bool
+}
+
+// GridCell is a column of a GridRow. It holds the panels stacked in the column from top to bottom,
+// or no panels for a horizontal gap between panels.
+type GridCell struct {
+	Panels []grafana.Panel
+	// X and W are the left and the width of the cell in grid units
+	X, W float64
+}
+
+// Gap reports whether the cell is a horizontal gap without panels
+func (c GridCell) Gap() bool {
+	return len(c.Panels) == 0
+}
+
+// Width returns the width of the cell as a fraction of the text width
+func (c GridCell) Width() float64 {
+	return c.W / gridColumns
+}
+
+// layoutGrid lays panels out as on the dashboard grid. Panels are sorted by GridPos Y and X, and grouped into rows of
+// panels that overlap vertically. Panels of a row that overlap horizontally are stacked in one cell, and the space
+// left of cells becomes gap cells. Panels without a grid position, as on Grafana 4 dashboards, take a row each.
+// Rows break pages as the mode breaks, and before panels marked with a page break.
+// With PanelPerPage, each panel takes a full width row.
+func layoutGrid(panels []grafana.Panel, mode PageBreaks) []GridRow {
+	if mode == PanelPerPage {
+		return layoutPanelPerPage(panels)
+	}
+
+	sorted := make([]grafana.Panel, len(panels))
+	copy(sorted, panels)
+	for i, p := range sorted {
+		if p.GridPos.W <= 0 {
+			sorted[i].GridPos.W = gridColumns
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].GridPos.Y != sorted[j].GridPos.Y {
+			return sorted[i].GridPos.Y < sorted[j].GridPos.Y
+		}
+		return sorted[i].GridPos.X < sorted[j].GridPos.X
+	})
+
+	var rows []GridRow
+	var band []grafana.Panel
+	var bottom float64
+	for _, p := range sorted {
+		if len(band) > 0 && p.GridPos.Y >= bottom {
+			rows = append(rows, newGridRow(band, bottom))
+			band = nil
+		}
+		if len(band) == 0 || p.GridPos.Y+p.GridPos.H > bottom {
+			bottom = p.GridPos.Y + p.GridPos.H
+		}
+		band = append(band, p)
+	}
+	if len(band) > 0 {
+		rows = append(rows, newGridRow(band, bottom))
+	}
+	if mode == RowPerPage {
+		for i := range rows {
+			rows[i].PageBreak = rows[i].PageBreak || i > 0
+		}
+	}
+	return rows
+}
+
+// layoutPanelPerPage lays each panel out in a full width row on a page of its own, in grid order
+func layoutPanelPerPage(panels []grafana.Panel) []GridRow {
+	var rows []GridRow
+	for _, r := range layoutGrid(panels, Continuous) {
+		for _, c := range r.Cells {
+			for _, p := range c.Panels {
+				cell := GridCell{Panels: []grafana.Panel{p}, W: gridColumns}
+				rows = append(rows, GridRow{Cells: []GridCell{cell}, Y: p.GridPos.Y, H: p.GridPos.H, PageBreak: len(rows) > 0 || p.PageBreak})
+			}
+		}
+	}
+	return rows
+}
+
+// newGridRow creates the row of the panels of a band, which are sorted by Y and X.
+// Cells are the union of the horizontal extents of the panels, so that no two cells overlap
+// and the cells never add up to more than the grid width.
+func newGridRow(band []grafana.Panel, bottom float64) GridRow {
+	byX := make([]grafana.Panel, len(band))
+	copy(byX, band)
+	sort.SliceStable(byX, func(i, j int) bool { return byX[i].GridPos.X < byX[j].GridPos.X })
+
+	var cells []GridCell
+	for _, p := range byX {
+		if n := len(cells); n > 0 && p.GridPos.X < cells[n-1].X+cells[n-1].W {
+			c := &cells[n-1]
+			if right := p.GridPos.X + p.GridPos.W; right > c.X+c.W {
+				c.W = right - c.X
+			}
+			c.Panels = append(c.Panels, p)
+			continue
+		}
+		cells = append(cells, GridCell{Panels: []grafana.Panel{p}, X: p.GridPos.X, W: p.GridPos.W})
+	}
+	//stack the panels of each cell from top to bottom
+	for _, c := range cells {
+		sort.SliceStable(c.Panels, func(i, j int) bool {
+			if c.Panels[i].GridPos.Y != c.Panels[j].GridPos.Y {
+				return c.Panels[i].GridPos.Y < c.Panels[j].GridPos.Y
+			}
+			return c.Panels[i].GridPos.X < c.Panels[j].GridPos.X
+		})
+	}
+
+	row := GridRow{Y: band[0].GridPos.Y, H: bottom - band[0].GridPos.Y}
+	for _, p := range band {
+		row.PageBreak = row.PageBreak || p.PageBreak
+	}
+	var end float64
+	for _, c := range cells {
+		if c.X > end {
+			row.Cells = append(row.Cells, GridCell{X: end, W: c.X - end})
+		}
+		row.Cells = append(row.Cells, c)
+		if c.X+c.W > end {
+			end = c.X + c.W
+		}
+	}
+	return row
+}
+
+// GridRows lays the panels of the dashboard out as on the dashboard grid, breaking pages as the report does
+func (d templateData) GridRows() []GridRow {
+	return layoutGrid(d.Panels, d.PageBreaks)
+}
+
+// BreakBefore reports whether the i-th panel of the dashboard starts a new page, as the report breaks pages.
+// With RowPerPage, a panel starts a new page if it is in another row than the previous panel: a dashboard row
+// on Grafana 4, a row of the dashboard grid otherwise.
+func (d templateData) BreakBefore(i int) bool {
+	if i < 0 || i >= len(d.Panels) {
+		return false
+	}
+	if d.Panels[i].PageBreak {
+		return true
+	}
+	if i == 0 {
+		return false
+	}
+	switch d.PageBreaks {
+	case PanelPerPage:
+		return true
+	case RowPerPage:
+		rows := d.panelRows()
+		return rows[d.Panels[i].Id] != rows[d.Panels[i-1].Id]
+	}
+	return false
+}
+
+// panelRowCache holds the row of each panel while a template executes, as templates call BreakBefore for every panel
+type panelRowCache struct {
+	rows map[int]int
+}
+
+// panelRows returns the index of the row of each panel, by panel id, laying the grid out once per template execution
+func (d templateData) panelRows() map[int]int {
+	if d.rowCache != nil && d.rowCache.rows != nil {
+		return d.rowCache.rows
+	}
+	rows := d.layoutPanelRows()
+	if d.rowCache != nil {
+		d.rowCache.rows = rows
+	}
+	return rows
+}
+
+func (d templateData) layoutPanelRows() map[int]int {
+	rows := map[int]int{}
+	if len(d.Rows) > 0 {
+		for i, r := range d.Rows {
+			for _, p := range r.Panels {
+				rows[p.Id] = i
+			}
+		}
+		return rows
+	}
+	for i, r := range layoutGrid(d.Panels, Continuous) {
+		for _, c := range r.Cells {
+			for _, p := range c.Panels {
+				rows[p.Id] = i
+			}
+		}
+	}
+	return rows
+}
@@ -0,0 +1,184 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mlesar/grafana-report/grafana"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func panelAt(id int, x, y, w, h float64) grafana.Panel {
+	return grafana.Panel{Id: id, Type: "graph", GridPos: grafana.GridPos{X: x, Y: y, W: w, H: h}}
+}
+
+func cellIds(row GridRow) [][]int {
+	var ids [][]int
+	for _, c := range row.Cells {
+		cell := []int{}
+		for _, p := range c.Panels {
+			cell = append(cell, p.Id)
+		}
+		ids = append(ids, cell)
+	}
+	return ids
+}
+
+func TestLayoutGrid(t *testing.T) {
+	convey.Convey("When laying out panels on the grid", t, func(c convey.C) {
+		c.Convey("It should sort unsorted panels into rows by Y and X", func(c convey.C) {
+			rows := layoutGrid([]grafana.Panel{
+				panelAt(3, 0, 8, 24, 8),
+				panelAt(2, 12, 0, 12, 8),
+				panelAt(1, 0, 0, 12, 8),
+			}, Continuous)
+			c.So(rows, convey.ShouldHaveLength, 2)
+			c.So(cellIds(rows[0]), convey.ShouldResemble, [][]int{{1}, {2}})
+			c.So(cellIds(rows[1]), convey.ShouldResemble, [][]int{{3}})
+			c.So(rows[1].Y, convey.ShouldEqual, 8)
+			c.So(rows[1].H, convey.ShouldEqual, 8)
+			c.So(rows[0].Cells[1].Width(), convey.ShouldEqual, 0.5)
+		})
+
+		c.Convey("It should preserve horizontal gaps", func(c convey.C) {
+			rows := layoutGrid([]grafana.Panel{panelAt(1, 6, 0, 6, 4), panelAt(2, 18, 0, 6, 4)}, Continuous)
+			c.So(rows, convey.ShouldHaveLength, 1)
+			c.So(cellIds(rows[0]), convey.ShouldResemble, [][]int{{}, {1}, {}, {2}})
+			c.So(rows[0].Cells[0].Gap(), convey.ShouldBeTrue)
+			c.So(rows[0].Cells[0].W, convey.ShouldEqual, 6)
+			c.So(rows[0].Cells[2].X, convey.ShouldEqual, 12)
+			c.So(rows[0].Cells[2].W, convey.ShouldEqual, 6)
+		})
+
+		c.Convey("It should stack panels beside a taller panel in one row", func(c convey.C) {
+			rows := layoutGrid([]grafana.Panel{
+				panelAt(1, 0, 0, 12, 8),
+				panelAt(2, 12, 0, 12, 4),
+				panelAt(3, 12, 4, 12, 4),
+				panelAt(4, 0, 8, 24, 6),
+			}, Continuous)
+			c.So(rows, convey.ShouldHaveLength, 2)
+			c.So(cellIds(rows[0]), convey.ShouldResemble, [][]int{{1}, {2, 3}})
+			c.So(rows[0].H, convey.ShouldEqual, 8)
+		})
+
+		c.Convey("It should merge cells that overlap once widened", func(c convey.C) {
+			rows := layoutGrid([]grafana.Panel{
+				panelAt(1, 0, 0, 12, 16),
+				panelAt(2, 12, 0, 12, 8),
+				panelAt(3, 6, 8, 12, 8),
+			}, Continuous)
+			c.So(rows, convey.ShouldHaveLength, 1)
+			c.So(cellIds(rows[0]), convey.ShouldResemble, [][]int{{1, 2, 3}})
+			c.So(rows[0].Cells[0].Width(), convey.ShouldEqual, 1)
+		})
+
+		c.Convey("Panels without a grid position should take a row each", func(c convey.C) {
+			rows := layoutGrid([]grafana.Panel{{Id: 1}, {Id: 2}}, Continuous)
+			c.So(rows, convey.ShouldHaveLength, 2)
+			c.So(rows[0].Cells[0].Width(), convey.ShouldEqual, 1)
+		})
+	})
+
+	convey.Convey("When generating a report with the grid template", t, func(c convey.C) {
+		dash := grafana.Dashboard{Title: "Grid", Panels: []grafana.Panel{panelAt(2, 12, 0, 12, 8), panelAt(1, 0, 0, 6, 8)}}
+		rep, tex, err := generateTestTeX(c, dash, Options{GridLayout: true})
+		defer rep.Clean()
+
+		c.Convey("It should lay out the panels as on the dashboard", func(c convey.C) {
+			c.So(err, convey.ShouldBeNil)
+			first := strings.Index(string(tex), "{image1}")
+			second := strings.Index(string(tex), "{image2}")
+			c.So(first, convey.ShouldBeGreaterThan, 0)
+			c.So(second, convey.ShouldBeGreaterThan, first)
+			c.So(string(tex), convey.ShouldContainSubstring, `\begin{minipage}[t]{0.25\textwidth}`)
+			c.So(string(tex), convey.ShouldContainSubstring, `\end{minipage}\hspace*{0.25\textwidth}\begin{minipage}[t]{0.5\textwidth}`)
+		})
+	})
+}
+
+func TestPageBreaks(t *testing.T) {
+	convey.Convey("When breaking the pages of a report", t, func(c convey.C) {
+		panels := []grafana.Panel{
+			panelAt(1, 0, 0, 12, 8),
+			panelAt(2, 12, 0, 12, 8),
+			panelAt(3, 0, 8, 24, 8),
+			panelAt(4, 0, 16, 24, 8),
+		}
+		breaks := func(rows []GridRow) []bool {
+			var b []bool
+			for _, r := range rows {
+				b = append(b, r.PageBreak)
+			}
+			return b
+		}
+
+		c.Convey("Continuous reports should only break before marked panels", func(c convey.C) {
+			marked := append([]grafana.Panel{}, panels...)
+			marked[2].PageBreak = true
+			c.So(breaks(layoutGrid(marked, Continuous)), convey.ShouldResemble, []bool{false, true, false})
+		})
+
+		c.Convey("Row per page reports should break before each row", func(c convey.C) {
+			c.So(breaks(layoutGrid(panels, RowPerPage)), convey.ShouldResemble, []bool{false, true, true})
+		})
+
+		c.Convey("Panel per page reports should put each panel in a full width row", func(c convey.C) {
+			rows := layoutGrid(panels, PanelPerPage)
+			c.So(breaks(rows), convey.ShouldResemble, []bool{false, true, true, true})
+			c.So(cellIds(rows[1]), convey.ShouldResemble, [][]int{{2}})
+			c.So(rows[1].Cells[0].Width(), convey.ShouldEqual, 1)
+		})
+
+		c.Convey("BreakBefore should break before panels as the mode does", func(c convey.C) {
+			breakBefore := func(d templateData) []bool {
+				var b []bool
+				for i := range d.Panels {
+					b = append(b, d.BreakBefore(i))
+				}
+				return b
+			}
+			dash := grafana.Dashboard{Panels: panels}
+			c.So(breakBefore(templateData{Dashboard: dash, PageBreaks: Continuous}), convey.ShouldResemble, []bool{false, false, false, false})
+			c.So(breakBefore(templateData{Dashboard: dash, PageBreaks: RowPerPage}), convey.ShouldResemble, []bool{false, false, true, true})
+			c.So(breakBefore(templateData{Dashboard: dash, PageBreaks: PanelPerPage}), convey.ShouldResemble, []bool{false, true, true, true})
+
+			rows := grafana.Dashboard{Panels: []grafana.Panel{{Id: 1}, {Id: 2}, {Id: 3}},
+				Rows: []grafana.Row{{Panels: []grafana.Panel{{Id: 1}, {Id: 2}}}, {Panels: []grafana.Panel{{Id: 3}}}}}
+			c.So(breakBefore(templateData{Dashboard: rows, PageBreaks: RowPerPage}), convey.ShouldResemble, []bool{false, false, true})
+		})
+
+		c.Convey("BreakBefore should lay the grid out once per template execution", func(c convey.C) {
+			d := templateData{Dashboard: grafana.Dashboard{Panels: panels}, PageBreaks: RowPerPage, rowCache: &panelRowCache{}}
+			c.So(d.BreakBefore(2), convey.ShouldBeTrue)
+			c.So(d.rowCache.rows, convey.ShouldNotBeNil)
+			d.rowCache.rows = map[int]int{}
+			c.So(d.BreakBefore(2), convey.ShouldBeFalse)
+		})
+
+		c.Convey("The default templates should break pages", func(c convey.C) {
+			for _, gridLayout := range []bool{false, true} {
+				rep, tex, err := generateTestTeX(c, grafana.Dashboard{Panels: panels}, Options{GridLayout: gridLayout, PageBreaks: PanelPerPage})
+				defer rep.Clean()
+				c.So(err, convey.ShouldBeNil)
+				c.So(strings.Count(tex, `\clearpage`), convey.ShouldEqual, 3)
+			}
+		})
+	})
+}
@@ -0,0 +1,107 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package metrics holds the Prometheus metrics of report generation.
+// Metrics are always collected, they are only exposed if the host program
+// serves Handler() (e.g. on /metrics) or registers them with Register().
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "grafana_reporter"
+
+var (
+	// Reports counts generated reports by dashboard and result ("success" or "failure")
+	Reports = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reports_total",
+		Help:      "Number of reports generated, by dashboard and result.",
+	}, []string{"dashboard", "result"})
+
+	// PanelRenderDuration observes the time Grafana takes to render a panel, including retries
+	PanelRenderDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "panel_render_duration_seconds",
+		Help:      "Time taken to obtain a panel render from Grafana, including retries.",
+		Buckets:   []float64{0.5, 1, 2.5, 5, 10, 20, 30, 60},
+	})
+
+	// PanelRenderRetries counts retried panel render requests
+	PanelRenderRetries = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "panel_render_retries_total",
+		Help:      "Number of panel render requests that were retried.",
+	})
+
+	// LaTeXDuration observes the time taken by pdflatex, by pass ("draft" or "final")
+	LaTeXDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "latex_duration_seconds",
+		Help:      "Time taken by a pdflatex pass.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"pass"})
+
+	// RenderQueueDepth is the number of panels waiting for a render worker
+	RenderQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "render_queue_depth",
+		Help:      "Number of panels waiting for a render worker.",
+	})
+
+	// CacheRequests counts cache lookups by cache ("panel" or "dashboard") and result ("hit" or "miss")
+	CacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_requests_total",
+		Help:      "Number of cache lookups, by cache and result.",
+	}, []string{"cache", "result"})
+)
+
+var registry = prometheus.NewRegistry()
+
+func init() {
+	Register(registry)
+}
+
+// Register registers the report generation metrics with r, e.g. prometheus.DefaultRegisterer
+func Register(r prometheus.Registerer) {
+	r.MustRegister(Reports, PanelRenderDuration, PanelRenderRetries, LaTeXDuration, RenderQueueDepth, CacheRequests)
+}
+
+// Handler returns an http.Handler exposing the report generation metrics in the Prometheus format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// Result returns the result label for an operation that returned err
+func Result(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+// CacheResult returns the result label for a cache lookup
+func CacheResult(hit bool) string {
+	if hit {
+		return "hit"
+	}
+	return "miss"
+}
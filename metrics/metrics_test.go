@@ -0,0 +1,60 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package metrics
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestMetrics(t *testing.T) {
+	convey.Convey("When serving the metrics handler", t, func(c convey.C) {
+		Reports.WithLabelValues("testDash", Result(nil)).Inc()
+		Reports.WithLabelValues("testDash", Result(errors.New("failed"))).Inc()
+		CacheRequests.WithLabelValues("panel", CacheResult(true)).Inc()
+
+		ts := httptest.NewServer(Handler())
+		defer ts.Close()
+		resp, err := ts.Client().Get(ts.URL)
+		c.So(err, convey.ShouldBeNil)
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		s := string(body)
+
+		c.Convey("It should expose report results by dashboard", func(c convey.C) {
+			c.So(s, convey.ShouldContainSubstring, `grafana_reporter_reports_total{dashboard="testDash",result="success"}`)
+			c.So(s, convey.ShouldContainSubstring, `grafana_reporter_reports_total{dashboard="testDash",result="failure"}`)
+		})
+
+		c.Convey("It should expose cache lookups", func(c convey.C) {
+			c.So(s, convey.ShouldContainSubstring, `grafana_reporter_cache_requests_total{cache="panel",result="hit"}`)
+		})
+
+		c.Convey("It should expose the render queue depth", func(c convey.C) {
+			c.So(s, convey.ShouldContainSubstring, "grafana_reporter_render_queue_depth 0")
+		})
+	})
+
+	convey.Convey("When registering the metrics with another registry", t, func(c convey.C) {
+		c.So(func() { Register(prometheus.NewRegistry()) }, convey.ShouldNotPanic)
+	})
+}
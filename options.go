@@ -0,0 +1,233 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/mlesar/grafana-report/grafana"
+)
+
+// Options configures a Report. The zero value creates the same report as New with an empty template.
+type Options struct {
+	// TexTemplate is the content of a LaTeX template file. If empty, a default template is used.
+	TexTemplate string
+	// Template is a template of a TemplateRegistry. If set, it is used instead of TexTemplate.
+	Template *Template
+	// GridLayout lays out panels as on the dashboard grid, and selects the default grid template.
+	// If Template is set, it is set from the layout of the Template.
+	// The Grafana client should be created with the same gridLayout.
+	GridLayout bool
+	// Theme is the theme panels are rendered in. It is passed to the template as .Theme to colour the page.
+	// The zero value is LightTheme.
+	Theme Theme
+	// VectorPanels requests panels as PDF, or as SVG if rsvg-convert is available to convert them, so that they stay sharp at any zoom.
+	// Panels are rendered as PNG if the Grafana image renderer does not support vector output.
+	VectorPanels bool
+	// Page is the paper size, orientation and margins of the report
+	Page Page
+	// PageBreaks is where the default templates start new pages. The zero value is Continuous.
+	// Panels whose description, or whose row title, contains #pagebreak always start a new page.
+	PageBreaks PageBreaks
+	// Captions adds a figure caption to each panel of the default templates, with the panel title, description,
+	// unit and time range if the panel overrides the dashboard time range
+	Captions bool
+	// ListOfFigures adds a list of the panels after the title of the default templates. It implies Captions.
+	ListOfFigures bool
+	// TableOfContents adds a table of contents of the rows and panels after the title of the default templates.
+	// The PDF has bookmarks of the rows and panels either way.
+	TableOfContents bool
+	// Author is the author in the PDF metadata. It defaults to the company of the Branding.
+	Author string
+	// Branding is printed on every page by templates that include the branding partial, as the default templates do
+	Branding Branding
+	// Logger logs the generation of the report. If nil, the logger set with SetLogger is used.
+	// The Grafana client logs through grafana.SetLogger either way.
+	Logger *slog.Logger
+}
+
+// withDefaults fills in the options that are not set
+func (o Options) withDefaults() Options {
+	if o.Template != nil {
+		o.GridLayout = o.Template.GridLayout()
+	}
+	o.Theme = o.Theme.withDefaults()
+	o.Page = o.Page.withDefaults(o.GridLayout)
+	o.Branding = o.Branding.withDefaults(o.Theme)
+	o.Captions = o.Captions || o.ListOfFigures
+	if o.Author == "" {
+		o.Author = o.Branding.Company
+	}
+	if o.PageBreaks == "" {
+		o.PageBreaks = Continuous
+	}
+	return o
+}
+
+// validate checks the options, whose defaults are filled in, so that invalid options fail before LaTeX runs
+func (o Options) validate() error {
+	switch o.PageBreaks {
+	case Continuous, PanelPerPage, RowPerPage:
+	default:
+		return fmt.Errorf("unknown page breaks %q, use %q, %q or %q", o.PageBreaks, Continuous, PanelPerPage, RowPerPage)
+	}
+	if err := o.Theme.validate(); err != nil {
+		return err
+	}
+	if err := o.Page.validate(); err != nil {
+		return err
+	}
+	return o.Branding.validate()
+}
+
+// PageBreaks is a page break mode of a report
+type PageBreaks string
+
+// Page break modes
+const (
+	// Continuous fills pages with panels, breaking pages between rows of panels where needed
+	Continuous PageBreaks = "continuous"
+	// PanelPerPage puts each panel on a page of its own
+	PanelPerPage PageBreaks = "panel"
+	// RowPerPage starts each row of panels on a new page
+	RowPerPage PageBreaks = "row"
+)
+
+// Theme is a Grafana theme together with the colours of its panels, in the HTML notation of the LaTeX xcolor package
+type Theme struct {
+	// Name is the Grafana theme, e.g. grafana.DarkTheme or the id of a custom theme
+	Name string
+	// Background is the panel background colour, e.g. FFFFFF
+	Background string
+	// Text is the text colour, e.g. 24292E
+	Text string
+}
+
+// Themes matching the panel colours of the builtin Grafana themes
+var (
+	LightTheme = Theme{grafana.LightTheme, "FFFFFF", "24292E"}
+	DarkTheme  = Theme{grafana.DarkTheme, "181B1F", "CCCCDC"}
+)
+
+// withDefaults fills in the parts of the theme that are not set from the light theme
+func (t Theme) withDefaults() Theme {
+	t.Background = strings.TrimPrefix(t.Background, "#")
+	t.Text = strings.TrimPrefix(t.Text, "#")
+	if t.Name == "" {
+		t.Name = LightTheme.Name
+	}
+	if t.Background == "" {
+		t.Background = LightTheme.Background
+	}
+	if t.Text == "" {
+		t.Text = LightTheme.Text
+	}
+	return t
+}
+
+// htmlColour matches colours in the HTML notation of the xcolor package, which \definecolor accepts
+var htmlColour = regexp.MustCompile(`^[0-9A-Fa-f]{6}$`)
+
+// checkColour returns an error if the colour named name is not in the HTML notation
+func checkColour(name string, colour string) error {
+	if !htmlColour.MatchString(colour) {
+		return fmt.Errorf("%s colour %q is not 6 hexadecimal digits, e.g. 1F60C4", name, colour)
+	}
+	return nil
+}
+
+func (t Theme) validate() error {
+	if err := checkColour("theme background", t.Background); err != nil {
+		return err
+	}
+	return checkColour("theme text", t.Text)
+}
+
+// IsDark reports whether the theme has a dark background
+func (t Theme) IsDark() bool {
+	var r, g, b int
+	if n, _ := fmt.Sscanf(t.Background, "%02x%02x%02x", &r, &g, &b); n != 3 {
+		return t.Name == grafana.DarkTheme
+	}
+	//perceived brightness, see https://www.w3.org/TR/AERT/#color-contrast
+	return (r*299+g*587+b*114)/1000 < 128
+}
+
+// Paper sizes of Page
+const (
+	LetterPaper = "letterpaper"
+	A4Paper     = "a4paper"
+	A3Paper     = "a3paper"
+)
+
+// Page is the paper size, orientation and margins of a report, passed to the template as .Page.
+// Panel widths are relative to the text width, so they follow the page.
+type Page struct {
+	// Paper is LetterPaper, A4Paper or A3Paper, with or without the paper suffix. It defaults to LetterPaper.
+	Paper string
+	// Landscape turns the page sideways, e.g. for wide dashboards
+	Landscape bool
+	// Margin is the page margin as a LaTeX length in mm, cm, in or pt, e.g. 15mm.
+	// It defaults to 1in, or 0.5in for the grid layout.
+	Margin string
+}
+
+// withDefaults fills in the paper size and the margin of the layout
+func (p Page) withDefaults(gridLayout bool) Page {
+	p.Paper = strings.ToLower(p.Paper)
+	if p.Paper == "" {
+		p.Paper = LetterPaper
+	}
+	if !strings.HasSuffix(p.Paper, "paper") {
+		p.Paper += "paper"
+	}
+	if p.Margin == "" {
+		p.Margin = "1in"
+		if gridLayout {
+			p.Margin = "0.5in"
+		}
+	}
+	return p
+}
+
+// margin matches the lengths that Page accepts as its margin
+var margin = regexp.MustCompile(`^\d+(\.\d+)?(mm|cm|in|pt)$`)
+
+// validate checks the paper size and the margin, filled in by withDefaults, as both go into the preamble unescaped
+func (p Page) validate() error {
+	switch p.Paper {
+	case LetterPaper, A4Paper, A3Paper:
+	default:
+		return fmt.Errorf("unknown paper %q, use %q, %q or %q", p.Paper, LetterPaper, A4Paper, A3Paper)
+	}
+	if !margin.MatchString(p.Margin) {
+		return fmt.Errorf("margin %q is not a length in mm, cm, in or pt, e.g. 15mm", p.Margin)
+	}
+	return nil
+}
+
+// Geometry returns the options of the LaTeX geometry package that set up the page, e.g. a4paper,landscape,margin=1in
+func (p Page) Geometry() string {
+	options := []string{p.Paper}
+	if p.Landscape {
+		options = append(options, "landscape")
+	}
+	return strings.Join(append(options, "margin="+p.Margin), ",")
+}
@@ -0,0 +1,109 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/mlesar/grafana-report/grafana"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestThemes(t *testing.T) {
+	convey.Convey("When choosing a theme", t, func(c convey.C) {
+		c.Convey("The builtin themes should be light and dark", func(c convey.C) {
+			c.So(LightTheme.IsDark(), convey.ShouldBeFalse)
+			c.So(DarkTheme.IsDark(), convey.ShouldBeTrue)
+		})
+
+		c.Convey("Custom themes should default to the light theme colours", func(c convey.C) {
+			theme := Theme{Name: "aubergine"}.withDefaults()
+			c.So(theme.Name, convey.ShouldEqual, "aubergine")
+			c.So(theme.Background, convey.ShouldEqual, LightTheme.Background)
+			c.So(theme.Text, convey.ShouldEqual, LightTheme.Text)
+		})
+
+		c.Convey("Reports should default to the light theme", func(c convey.C) {
+			rep := New(&mockGrafanaClient{0, url.Values{}}, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", false)
+			c.So(rep.opts.Theme, convey.ShouldResemble, LightTheme)
+		})
+
+		c.Convey("The default template should colour the page with the theme", func(c convey.C) {
+			rep, tex, err := generateTestTeX(c, testDashboard(), Options{Theme: DarkTheme})
+			defer rep.Clean()
+			c.So(err, convey.ShouldBeNil)
+			c.So(tex, convey.ShouldContainSubstring, `\definecolor{background}{HTML}{181B1F}`)
+			c.So(tex, convey.ShouldContainSubstring, `\definecolor{foreground}{HTML}{CCCCDC}`)
+		})
+
+		c.Convey("Reports should reject theme colours that are not 6 hexadecimal digits", func(c convey.C) {
+			gClient := &mockGrafanaClient{0, url.Values{}}
+			for _, theme := range []Theme{{Background: "red"}, {Text: "#abc"}, {Background: "1F60C4 "}} {
+				_, err := NewWithOptions(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, Options{Theme: theme})
+				c.So(err, convey.ShouldNotBeNil)
+			}
+			rep := newTestReport(c, gClient, Options{Theme: Theme{Background: "#1F60C4", Text: "ffffff"}})
+			c.So(rep.opts.Theme.Background, convey.ShouldEqual, "1F60C4")
+		})
+	})
+}
+
+func TestPage(t *testing.T) {
+	convey.Convey("When choosing the page of a report", t, func(c convey.C) {
+		c.Convey("It should default to letter paper with the margin of the layout", func(c convey.C) {
+			c.So(Page{}.withDefaults(false).Geometry(), convey.ShouldEqual, "letterpaper,margin=1in")
+			c.So(Page{}.withDefaults(true).Geometry(), convey.ShouldEqual, "letterpaper,margin=0.5in")
+		})
+
+		c.Convey("It should set the paper size, orientation and margin", func(c convey.C) {
+			page := Page{Paper: "A3", Landscape: true, Margin: "15mm"}.withDefaults(true)
+			c.So(page.Paper, convey.ShouldEqual, A3Paper)
+			c.So(page.Geometry(), convey.ShouldEqual, "a3paper,landscape,margin=15mm")
+		})
+
+		c.Convey("The default templates should set up the page", func(c convey.C) {
+			for _, gridLayout := range []bool{false, true} {
+				rep, tex, err := generateTestTeX(c, testDashboard(), Options{GridLayout: gridLayout, Page: Page{Paper: A4Paper, Landscape: true}})
+				defer rep.Clean()
+				c.So(err, convey.ShouldBeNil)
+				c.So(tex, convey.ShouldContainSubstring, `\geometry{a4paper,landscape,margin=`+rep.opts.Page.Margin+`}`)
+			}
+		})
+
+		c.Convey("Reports should reject unknown paper sizes and invalid margins", func(c convey.C) {
+			gClient := &mockGrafanaClient{0, url.Values{}}
+			for _, page := range []Page{{Paper: "b5"}, {Paper: `a4paper,\input{x}`}, {Margin: "1in}"}, {Margin: "1em"}, {Margin: ".5in"}} {
+				_, err := NewWithOptions(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, Options{Page: page})
+				c.So(err, convey.ShouldNotBeNil)
+			}
+			rep := newTestReport(c, gClient, Options{Page: Page{Paper: "A3", Margin: "2.5cm"}})
+			c.So(rep.opts.Page.Geometry(), convey.ShouldEqual, "a3paper,margin=2.5cm")
+		})
+
+		c.Convey("Reports should reject unknown page breaks", func(c convey.C) {
+			_, err := NewWithOptions(&mockGrafanaClient{0, url.Values{}}, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, Options{PageBreaks: "rows"})
+			c.So(err, convey.ShouldNotBeNil)
+		})
+
+		c.Convey("Grid templates should default to the grid margin", func(c convey.C) {
+			grid, _ := NewTemplateRegistry().Get("grid")
+			rep := newTestReport(c, &mockGrafanaClient{0, url.Values{}}, Options{Template: grid})
+			c.So(rep.opts.Page.Margin, convey.ShouldEqual, "0.5in")
+		})
+	})
+}
@@ -17,34 +17,50 @@
 package report
 
 import (
+	"context"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"sync"
-	"text/template"
 
 	"github.com/mlesar/grafana-report/grafana"
+	"github.com/mlesar/grafana-report/metrics"
+	"github.com/mlesar/grafana-report/tracing"
 	"github.com/pborman/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Report groups functions related to generating the report.
-// After reading and closing the pdf returned by Generate(), call Clean() to delete the pdf file as well the temporary build files
+// After reading and closing the pdf returned by Generate(), call Clean() to delete the pdf file as well the temporary build files.
+// The reports of this package also implement ContextReport.
 type Report interface {
 	Generate() (pdf io.ReadCloser, err error)
 	Title() string
 	Clean() error
 }
 
+// ContextReport is a Report that generates within a context, to cancel it or to trace it as part of the caller's trace
+type ContextReport interface {
+	Report
+	GenerateContext(ctx context.Context) (pdf io.ReadCloser, err error)
+}
+
 type report struct {
+	id          string
 	gClient     grafana.Client
 	time        grafana.TimeRange
 	texTemplate string
 	dashName    string
 	tmpDir      string
 	dashTitle   string
+	log         *slog.Logger
+	opts        Options
 }
 
 const (
@@ -56,47 +72,132 @@ const (
 // New creates a new Report.
 // texTemplate is the content of a LaTex template file. If empty, a default tex template is used.
 func New(g grafana.Client, dashName string, time grafana.TimeRange, texTemplate string, gridLayout bool) *report {
+	return newReport(g, dashName, time, Options{TexTemplate: texTemplate, GridLayout: gridLayout}.withDefaults())
+}
+
+// NewWithOptions creates a new Report configured by opts.
+// It fails if opts are invalid, e.g. if a colour is not in the HTML notation.
+func NewWithOptions(g grafana.Client, dashName string, time grafana.TimeRange, opts Options) (*report, error) {
+	opts = opts.withDefaults()
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	return newReport(g, dashName, time, opts), nil
+}
+
+// newReport creates a Report configured by opts, whose defaults are filled in
+func newReport(g grafana.Client, dashName string, time grafana.TimeRange, opts Options) *report {
+	texTemplate := opts.TexTemplate
+	if opts.Template != nil {
+		texTemplate = opts.Template.text
+	}
 	if texTemplate == "" {
-		if gridLayout {
+		if opts.GridLayout {
 			texTemplate = defaultGridTemplate
 		} else {
 			texTemplate = defaultTemplate
 		}
 
 	}
-	tmpDir := filepath.Join("tmp", uuid.New())
-	return &report{g, time, texTemplate, dashName, tmpDir, ""}
+	id := uuid.New()
+	tmpDir := filepath.Join("tmp", id)
+	log := opts.Logger
+	if log == nil {
+		log = logger()
+	}
+	log = log.With("report", id, "dashboard", dashName)
+	return &report{id, g, time, texTemplate, dashName, tmpDir, "", log, opts}
+}
+
+// NewFromSearch creates a Report for each dashboard matching q, e.g. every dashboard tagged weekly-report.
+// The other arguments are used as for NewWithOptions. No dashboard matching q is not an error.
+func NewFromSearch(ctx context.Context, g grafana.Client, q grafana.SearchQuery, time grafana.TimeRange, opts Options) ([]Report, error) {
+	opts = opts.withDefaults()
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	refs, err := g.SearchDashboards(ctx, q)
+	if err != nil {
+		return nil, fmt.Errorf("error searching dashboards: %w", err)
+	}
+	return newReports(g, refs, time, opts), nil
+}
+
+// newReports creates a Report for each dashboard of refs, configured by opts, whose defaults are filled in
+func newReports(g grafana.Client, refs []grafana.DashboardRef, time grafana.TimeRange, opts Options) []Report {
+	reports := make([]Report, 0, len(refs))
+	for _, r := range refs {
+		reports = append(reports, newReport(g, r.Name, time, opts))
+	}
+	return reports
+}
+
+// SetLogger sets the structured logger used to log report generation and by the Grafana client, see grafana.SetLogger.
+// Reports created with Options.Logger log there instead.
+func SetLogger(l *slog.Logger) {
+	grafana.SetLogger(l)
+}
+
+func logger() *slog.Logger {
+	return grafana.Logger()
 }
 
 // Generate returns the report.pdf file.  After reading this file it should be Closed()
 // After closing the file, call report.Clean() to delete the file as well the temporary build files
 func (rep *report) Generate() (pdf io.ReadCloser, err error) {
-	dash, err := rep.gClient.GetDashboard(rep.dashName)
+	return rep.GenerateContext(context.Background())
+}
+
+// GenerateContext is Generate with a context, which cancels the generation of the report when done
+// and whose trace the spans of the report are part of
+func (rep *report) GenerateContext(ctx context.Context) (pdf io.ReadCloser, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "report.Generate",
+		trace.WithAttributes(attribute.String("report", rep.id), attribute.String("dashboard", rep.dashName)))
+	defer func() {
+		tracing.End(span, err)
+		metrics.Reports.WithLabelValues(rep.dashName, metrics.Result(err)).Inc()
+		if err != nil {
+			rep.log.Error("generating report failed", "err", err)
+		} else {
+			rep.log.Info("report generated")
+		}
+	}()
+
+	dash, err := rep.gClient.GetDashboard(ctx, rep.dashName)
 	if err != nil {
 		err = fmt.Errorf("error fetching dashboard %s: %w", rep.dashName, err)
 		return
 	}
 	rep.dashTitle = dash.Title
 
-	err = rep.renderPNGsParallel(dash)
+	err = rep.renderPanelsParallel(ctx, dash)
+	//the panels are rendered, so the client can free what it holds for the dashboard, e.g. the temporary dashboard of local JSON
+	if releaseErr := grafana.Release(ctx, rep.gClient, rep.dashName); releaseErr != nil {
+		rep.log.Warn("releasing dashboard failed", "err", releaseErr)
+	}
 	if err != nil {
-		err = fmt.Errorf("error rendering PNGs in parralel for dash %+v: %w", dash, err)
+		err = fmt.Errorf("error rendering panels in parralel for dash %s: %w", rep.dashName, err)
 		return
 	}
-	err = rep.generateTeXFile(dash)
+	err = rep.generateTeXFile(ctx, dash)
 	if err != nil {
-		err = fmt.Errorf("error generating TeX file for dash %+v: %w", dash, err)
+		err = fmt.Errorf("error generating TeX file for dash %s: %w", rep.dashName, err)
 		return
 	}
-	pdf, err = rep.runLaTeX()
+	pdf, err = runLaTeX(ctx, rep.tmpDir)
 	return
 }
 
 // Title returns the dashboard title parsed from the dashboard definition
 func (rep *report) Title() string {
+	return rep.TitleContext(context.Background())
+}
+
+// TitleContext is Title with a context for fetching the dashboard definition
+func (rep *report) TitleContext(ctx context.Context) string {
 	//lazy fetch if Title() is called before Generate()
 	if rep.dashTitle == "" {
-		dash, err := rep.gClient.GetDashboard(rep.dashName)
+		dash, err := rep.gClient.GetDashboard(ctx, rep.dashName)
 		if err != nil {
 			return ""
 		}
@@ -122,17 +223,20 @@ func (rep *report) texPath() string {
 	return filepath.Join(rep.tmpDir, reportTexFile)
 }
 
-func (rep *report) renderPNGsParallel(dash grafana.Dashboard) error {
+func (rep *report) renderPanelsParallel(ctx context.Context, dash grafana.Dashboard) error {
 	//buffer all panels on a channel
 	panels := make(chan grafana.Panel, len(dash.Panels))
 	for _, p := range dash.Panels {
 		panels <- p
 	}
 	close(panels)
+	metrics.RenderQueueDepth.Add(float64(len(dash.Panels)))
 
 	//fetch images in parrallel form Grafana sever.
 	//limit concurrency using a worker pool to avoid overwhelming grafana
 	//for dashboards with many panels.
+	//the formats are looked up once, rather than for every panel
+	formats := rep.imageFormats()
 	var wg sync.WaitGroup
 	workers := 5
 	wg.Add(workers)
@@ -141,9 +245,15 @@ func (rep *report) renderPNGsParallel(dash grafana.Dashboard) error {
 		go func(panels <-chan grafana.Panel, errs chan<- error) {
 			defer wg.Done()
 			for p := range panels {
-				err := rep.renderPNG(p)
+				metrics.RenderQueueDepth.Dec()
+				//stop rendering the remaining panels of cancelled reports
+				if err := ctx.Err(); err != nil {
+					errs <- err
+					continue
+				}
+				err := rep.renderPanel(ctx, p, formats)
 				if err != nil {
-					log.Printf("Error creating image for panel: %s", err)
+					rep.log.Error("rendering panel failed", "panel", p.Id, "err", err)
 					errs <- err
 				}
 			}
@@ -160,8 +270,11 @@ func (rep *report) renderPNGsParallel(dash grafana.Dashboard) error {
 	return nil
 }
 
-func (rep *report) renderPNG(p grafana.Panel) error {
-	body, err := rep.gClient.GetPanelPng(p, rep.dashName, rep.time)
+// renderPanel writes the panel image, in the first of formats the client renders, to the image directory
+// as image<Id>.<format>. Templates include images without the extension, so that LaTeX picks up vector images.
+func (rep *report) renderPanel(ctx context.Context, p grafana.Panel, formats []grafana.ImageFormat) error {
+	opts := grafana.RenderOptions{Theme: rep.opts.Theme.Name, Formats: formats}
+	body, format, err := rep.gClient.RenderPanel(ctx, p, rep.dashName, rep.time, opts)
 	if err != nil {
 		return fmt.Errorf("getting panel %+v: %w", p, err)
 	}
@@ -171,7 +284,7 @@ func (rep *report) renderPNG(p grafana.Panel) error {
 	if err != nil {
 		return fmt.Errorf("creating img directory:%v", err)
 	}
-	imgFileName := fmt.Sprintf("image%d.png", p.Id)
+	imgFileName := fmt.Sprintf("image%d.%s", p.Id, format)
 	file, err := os.Create(filepath.Join(rep.imgDirPath(), imgFileName))
 	if err != nil {
 		return fmt.Errorf("creating image file:%v", err)
@@ -182,17 +295,60 @@ func (rep *report) renderPNG(p grafana.Panel) error {
 	if err != nil {
 		return fmt.Errorf("copying body to file:%v", err)
 	}
+	if format == grafana.SVG {
+		return rep.convertSVG(ctx, imgFileName)
+	}
 	return nil
 }
 
-func (rep *report) generateTeXFile(dash grafana.Dashboard) error {
-	type templData struct {
-		grafana.Dashboard
-		grafana.TimeRange
-		grafana.Client
+// svgConverter converts SVG images to PDF, which pdflatex can include
+const svgConverter = "rsvg-convert"
+
+// imageFormats returns the panel image formats acceptable to the LaTeX backend, in order of preference
+func (rep *report) imageFormats() []grafana.ImageFormat {
+	if !rep.opts.VectorPanels {
+		return nil
 	}
+	formats := []grafana.ImageFormat{grafana.PDF}
+	if _, err := exec.LookPath(svgConverter); err == nil {
+		formats = append(formats, grafana.SVG)
+	}
+	return formats
+}
 
-	err := os.MkdirAll(rep.tmpDir, 0777)
+func (rep *report) convertSVG(ctx context.Context, svgFileName string) error {
+	pdfFileName := strings.TrimSuffix(svgFileName, filepath.Ext(svgFileName)) + ".pdf"
+	cmd := exec.CommandContext(ctx, svgConverter, "-f", "pdf", "-o", pdfFileName, svgFileName)
+	cmd.Dir = rep.imgDirPath()
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("converting %v to pdf: %v, output: %s", svgFileName, err, out)
+	}
+	return nil
+}
+
+// templateData is the data report templates are executed with
+type templateData struct {
+	grafana.Dashboard
+	grafana.TimeRange
+	grafana.Client
+	Theme      Theme
+	Page       Page
+	Branding   Branding
+	PageBreaks PageBreaks
+	// Captions, ListOfFigures, TableOfContents and Author are set as in Options
+	Captions, ListOfFigures, TableOfContents bool
+	Author                                   string
+
+	bookmarks *bookmarks
+	rowCache  *panelRowCache
+}
+
+func (rep *report) generateTeXFile(ctx context.Context, dash grafana.Dashboard) (err error) {
+	_, span := tracing.Tracer().Start(ctx, "report.generateTeXFile")
+	defer func() { tracing.End(span, err) }()
+
+	err = os.MkdirAll(rep.tmpDir, 0777)
 	if err != nil {
 		return fmt.Errorf("creating temporary directory at %v: %w", rep.tmpDir, err)
 	}
@@ -201,36 +357,90 @@ func (rep *report) generateTeXFile(dash grafana.Dashboard) error {
 		return fmt.Errorf("creating tex file at %v: %w", rep.texPath(), err)
 	}
 	defer file.Close()
+	branding, err := rep.copyLogo()
+	if err != nil {
+		return err
+	}
 
-	tmpl, err := template.New("report").Delims("[[", "]]").Parse(rep.texTemplate)
+	var partials []string
+	if rep.opts.Template != nil {
+		partials = rep.opts.Template.partials
+	}
+	return executeTemplate(file, "report", rep.texTemplate, partials, templateData{
+		Dashboard:       dash,
+		TimeRange:       rep.time,
+		Client:          rep.gClient,
+		Theme:           rep.opts.Theme,
+		Page:            rep.opts.Page,
+		Branding:        branding,
+		PageBreaks:      rep.opts.PageBreaks,
+		Captions:        rep.opts.Captions,
+		ListOfFigures:   rep.opts.ListOfFigures,
+		TableOfContents: rep.opts.TableOfContents,
+		Author:          rep.opts.Author,
+	})
+}
+
+// executeTemplate parses the TeX template text with its partials and executes it with data
+func executeTemplate(w io.Writer, name string, text string, partials []string, data templateData) error {
+	tmpl, err := parseTemplate(name, text, templateFuncs(data.Dashboard), partials...)
 	if err != nil {
-		return fmt.Errorf("parsing template %s: %w", rep.texTemplate, err)
+		return err
 	}
-	data := templData{dash, rep.time, rep.gClient}
-	err = tmpl.Execute(file, data)
+	data.bookmarks = &bookmarks{}
+	data.rowCache = &panelRowCache{}
+	err = tmpl.Execute(w, data)
 	if err != nil {
 		return fmt.Errorf("executing tex template: %w", err)
 	}
 	return nil
 }
 
-func (rep *report) runLaTeX() (pdf *os.File, err error) {
-	cmdPre := exec.Command("pdflatex", "-halt-on-error", "-draftmode", reportTexFile)
-	cmdPre.Dir = rep.tmpDir
+// runLaTeX compiles the report.tex file in dir to report.pdf and opens it.
+// If LaTeX reports an error in report.log, the error wraps a *LaTeXError.
+func runLaTeX(ctx context.Context, dir string) (pdf *os.File, err error) {
+	cmdPre := latexCommand(ctx, dir, "-draftmode")
+	timer := prometheus.NewTimer(metrics.LaTeXDuration.WithLabelValues("draft"))
+	_, span := tracing.Tracer().Start(ctx, "pdflatex", trace.WithAttributes(attribute.String("pass", "draft")))
 	outBytesPre, errPre := cmdPre.CombinedOutput()
+	tracing.End(span, errPre)
+	timer.ObserveDuration()
 	if errPre != nil {
+		if latexErr := readLaTeXError(dir); latexErr != nil {
+			err = fmt.Errorf("LaTeX preprocessing failed: %w", latexErr)
+			return
+		}
 		err = fmt.Errorf("calling LaTeX preprocessing: %q. Latex preprocessing failed with output: %s", errPre, outBytesPre)
 		return
 	}
 
-	cmd := exec.Command("pdflatex", "-halt-on-error", reportTexFile)
-	cmd.Dir = rep.tmpDir
+	cmd := latexCommand(ctx, dir)
+	timer = prometheus.NewTimer(metrics.LaTeXDuration.WithLabelValues("final"))
+	_, span = tracing.Tracer().Start(ctx, "pdflatex", trace.WithAttributes(attribute.String("pass", "final")))
 	outBytes, err := cmd.CombinedOutput()
+	tracing.End(span, err)
+	timer.ObserveDuration()
 	if err != nil {
+		if latexErr := readLaTeXError(dir); latexErr != nil {
+			err = fmt.Errorf("LaTeX failed: %w", latexErr)
+			return
+		}
 		err = fmt.Errorf("calling LaTeX: %q. Latex failed with output: %s", err, outBytes)
 		return
 	}
 
-	pdf, err = os.Open(rep.pdfPath())
+	pdf, err = os.Open(filepath.Join(dir, reportPdf))
 	return
 }
+
+// latexCommand creates a pdflatex command that compiles the report.tex file in dir, halting on the first error,
+// which is logged with the file and line it is in.
+// Shell escape is disabled, and LaTeX can only open files below dir or found in the TeX installation,
+// so that templates can neither run commands nor read the files of the server.
+func latexCommand(ctx context.Context, dir string, args ...string) *exec.Cmd {
+	args = append([]string{"-no-shell-escape", "-halt-on-error", "-file-line-error"}, args...)
+	cmd := exec.CommandContext(ctx, "pdflatex", append(args, reportTexFile)...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "openin_any=p", "openout_any=p")
+	return cmd
+}
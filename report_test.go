@@ -18,15 +18,21 @@ package report
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"io"
 	"io/ioutil"
+	"log/slog"
 	"net/url"
 	"os"
 	"testing"
 
 	"github.com/mlesar/grafana-report/grafana"
 	"github.com/smartystreets/goconvey/convey"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 const dashJSON = `
@@ -46,7 +52,7 @@ const dashJSON = `
 				{"Type":"graph", "Id":66},
 				{"Type":"graph", "Id":77},
 				{"Type":"graph", "Id":88},
-				{"Type":"graph", "Id":99}
+				{"Type":"graph", "Id":99, "timeShift":"1d"}
 			]
 		}]
 	},
@@ -59,13 +65,42 @@ type mockGrafanaClient struct {
 	variables         url.Values
 }
 
-func (m *mockGrafanaClient) GetDashboard(dashName string) (grafana.Dashboard, error) {
+func (m *mockGrafanaClient) GetDashboard(ctx context.Context, dashName string) (grafana.Dashboard, error) {
 	return grafana.NewDashboard([]byte(dashJSON), m.variables), nil
 }
 
-func (m *mockGrafanaClient) GetPanelPng(p grafana.Panel, dashName string, t grafana.TimeRange) (io.ReadCloser, error) {
+// RenderPanel renders the first of the requested formats
+func (m *mockGrafanaClient) RenderPanel(ctx context.Context, p grafana.Panel, dashName string, t grafana.TimeRange, opts grafana.RenderOptions) (io.ReadCloser, grafana.ImageFormat, error) {
 	m.getPanelCallCount++
-	return ioutil.NopCloser(bytes.NewBuffer([]byte("Not actually a png"))), nil
+	if len(opts.Formats) > 0 {
+		return ioutil.NopCloser(bytes.NewBuffer([]byte("Not actually a vector image"))), opts.Formats[0], nil
+	}
+	return ioutil.NopCloser(bytes.NewBuffer([]byte("Not actually a png"))), grafana.PNG, nil
+}
+
+func (m *mockGrafanaClient) SearchDashboards(ctx context.Context, q grafana.SearchQuery) ([]grafana.DashboardRef, error) {
+	return []grafana.DashboardRef{{Name: "testDash", UID: "testDash", Title: "My first dashboard"}, {Name: "otherDash", UID: "otherDash", Title: "Other"}}, nil
+}
+
+// testDashboard returns the dashboard of mockGrafanaClient
+func testDashboard() grafana.Dashboard {
+	return grafana.NewDashboard([]byte(dashJSON), url.Values{})
+}
+
+// generateTestTeX generates the TeX file of a report of dash configured by opts, and returns the report and the TeX.
+// The caller cleans the report up.
+func generateTestTeX(c convey.C, dash grafana.Dashboard, opts Options) (*report, string, error) {
+	rep := newTestReport(c, &mockGrafanaClient{0, url.Values{}}, opts)
+	err := rep.generateTeXFile(context.Background(), dash)
+	tex, _ := ioutil.ReadFile(rep.texPath())
+	return rep, string(tex), err
+}
+
+// newTestReport creates a report of testDash over the last hour, failing the test if opts are rejected
+func newTestReport(c convey.C, g grafana.Client, opts Options) *report {
+	rep, err := NewWithOptions(g, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, opts)
+	c.So(err, convey.ShouldBeNil)
+	return rep
 }
 
 func TestReport(t *testing.T) {
@@ -79,8 +114,8 @@ func TestReport(t *testing.T) {
 		}()
 
 		c.Convey("When rendering images", func(c convey.C) {
-			dashboard, _ := gClient.GetDashboard("")
-			rep.renderPNGsParallel(dashboard)
+			dashboard, _ := gClient.GetDashboard(context.Background(), "")
+			rep.renderPanelsParallel(context.Background(), dashboard)
 
 			c.Convey("It should create a temporary folder", func(c convey.C) {
 				_, err := os.Stat(rep.tmpDir)
@@ -110,8 +145,8 @@ func TestReport(t *testing.T) {
 		})
 
 		c.Convey("When genereting the Tex file", func(c convey.C) {
-			dashboard, _ := gClient.GetDashboard("")
-			rep.generateTeXFile(dashboard)
+			dashboard, _ := gClient.GetDashboard(context.Background(), "")
+			rep.generateTeXFile(context.Background(), dashboard)
 			f, err := os.Open(rep.texPath())
 			c.So(err, convey.ShouldBeNil)
 			defer func() {
@@ -153,6 +188,9 @@ func TestReport(t *testing.T) {
 					c.So(s, convey.ShouldContainSubstring, "Tue Jan 19")
 					c.So(s, convey.ShouldContainSubstring, "2016")
 				})
+				c.Convey("and the time range of panels with time overrides", func(c convey.C) {
+					c.So(s, convey.ShouldContainSubstring, "Mon Jan 18")
+				})
 			})
 		})
 
@@ -166,22 +204,141 @@ func TestReport(t *testing.T) {
 
 }
 
+func TestReportVectorPanels(t *testing.T) {
+	convey.Convey("When generating a report with vector panels", t, func(c convey.C) {
+		gClient := &mockGrafanaClient{0, url.Values{}}
+		rep := newTestReport(c, gClient, Options{VectorPanels: true})
+		defer rep.Clean()
+		dashboard, _ := gClient.GetDashboard(context.Background(), "")
+		err := rep.renderPanelsParallel(context.Background(), dashboard)
+
+		c.Convey("It should name image files by the rendered format", func(c convey.C) {
+			c.So(err, convey.ShouldBeNil)
+			_, err := os.Stat(rep.imgDirPath() + "/image1.pdf")
+			c.So(err, convey.ShouldBeNil)
+		})
+	})
+}
+
+// releasingClient records the dashboards released by reports, as a grafana.LocalClient deletes their temporary dashboards
+type releasingClient struct {
+	mockGrafanaClient
+	released []string
+}
+
+func (r *releasingClient) Release(ctx context.Context, dashName string) error {
+	r.released = append(r.released, dashName)
+	return nil
+}
+
+func TestReportReleasesDashboard(t *testing.T) {
+	convey.Convey("When generating a report", t, func(c convey.C) {
+		gClient := &releasingClient{mockGrafanaClient: mockGrafanaClient{0, url.Values{}}}
+		rep := newTestReport(c, grafana.NewCachingClient(gClient, grafana.NewMemoryPanelCache(10, 0)), Options{})
+		defer rep.Clean()
+		rep.Generate()
+
+		c.Convey("It should release the dashboard once the panels are rendered", func(c convey.C) {
+			c.So(gClient.released, convey.ShouldResemble, []string{"testDash"})
+		})
+	})
+}
+
+func TestReportGenerateContext(t *testing.T) {
+	convey.Convey("When generating a report within a context", t, func(c convey.C) {
+		exporter := tracetest.NewInMemoryExporter()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+		defer otel.SetTracerProvider(noop.NewTracerProvider())
+		rep := newTestReport(c, &mockGrafanaClient{0, url.Values{}}, Options{})
+		defer rep.Clean()
+
+		c.Convey("The report span should be part of the caller's trace", func(c convey.C) {
+			ctx, parent := otel.Tracer("test").Start(context.Background(), "caller")
+			rep.GenerateContext(ctx)
+			parent.End()
+			var found bool
+			for _, s := range exporter.GetSpans() {
+				if s.Name == "report.Generate" {
+					found = true
+					c.So(s.Parent.SpanID(), convey.ShouldEqual, parent.SpanContext().SpanID())
+				}
+			}
+			c.So(found, convey.ShouldBeTrue)
+		})
+
+		c.Convey("Cancelling the context should cancel the generation", func(c convey.C) {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			_, err := rep.GenerateContext(ctx)
+			c.So(errors.Is(err, context.Canceled), convey.ShouldBeTrue)
+		})
+	})
+}
+
+func TestReportLogger(t *testing.T) {
+	convey.Convey("When generating a report with its own logger", t, func(c convey.C) {
+		var own, shared bytes.Buffer
+		SetLogger(slog.New(slog.NewTextHandler(&shared, nil)))
+		defer SetLogger(nil)
+		rep := newTestReport(c, &errClient{0, url.Values{"var-token": {"s3cret"}}}, Options{Logger: slog.New(slog.NewTextHandler(&own, nil))})
+		defer rep.Clean()
+		rep.Generate()
+
+		c.Convey("It should log to its own logger only", func(c convey.C) {
+			c.So(own.String(), convey.ShouldContainSubstring, "generating report failed")
+			c.So(own.String(), convey.ShouldContainSubstring, "dashboard=testDash")
+			c.So(shared.String(), convey.ShouldBeEmpty)
+		})
+
+		c.Convey("It should identify the dashboard by name only, without its variables", func(c convey.C) {
+			c.So(own.String(), convey.ShouldNotContainSubstring, "s3cret")
+		})
+
+		c.Convey("SetLogger should set the logger of the Grafana client too", func(c convey.C) {
+			c.So(grafana.Logger().Handler(), convey.ShouldEqual, logger().Handler())
+		})
+	})
+}
+
+func TestReportsFromSearch(t *testing.T) {
+	convey.Convey("When creating reports for the dashboards matching a search", t, func(c convey.C) {
+		timeRange := grafana.TimeRange{From: "now-7d", To: "now"}
+
+		c.Convey("It should create one report per dashboard", func(c convey.C) {
+			reports, err := NewFromSearch(context.Background(), &mockGrafanaClient{0, url.Values{}}, grafana.SearchQuery{Tags: []string{"weekly-report"}}, timeRange, Options{})
+			c.So(err, convey.ShouldBeNil)
+			c.So(reports, convey.ShouldHaveLength, 2)
+			c.So(reports[0].(*report).dashName, convey.ShouldEqual, "testDash")
+			c.So(reports[1].(*report).dashName, convey.ShouldEqual, "otherDash")
+		})
+
+		c.Convey("It should return search errors", func(c convey.C) {
+			_, err := NewFromSearch(context.Background(), &errClient{0, url.Values{}}, grafana.SearchQuery{}, timeRange, Options{})
+			c.So(err, convey.ShouldNotBeNil)
+		})
+	})
+}
+
 type errClient struct {
 	getPanelCallCount int
 	variables         url.Values
 }
 
-func (e *errClient) GetDashboard(dashName string) (grafana.Dashboard, error) {
+func (e *errClient) GetDashboard(ctx context.Context, dashName string) (grafana.Dashboard, error) {
 	return grafana.NewDashboard([]byte(dashJSON), e.variables), nil
 }
 
 //Produce an error on the 2nd panel fetched
-func (e *errClient) GetPanelPng(p grafana.Panel, dashName string, t grafana.TimeRange) (io.ReadCloser, error) {
+func (e *errClient) RenderPanel(ctx context.Context, p grafana.Panel, dashName string, t grafana.TimeRange, opts grafana.RenderOptions) (io.ReadCloser, grafana.ImageFormat, error) {
 	e.getPanelCallCount++
 	if e.getPanelCallCount == 2 {
-		return nil, errors.New("The second panel has convey.some problem")
+		return nil, "", errors.New("The second panel has convey.some problem")
 	}
-	return ioutil.NopCloser(bytes.NewBuffer([]byte("Not actually a png"))), nil
+	return ioutil.NopCloser(bytes.NewBuffer([]byte("Not actually a png"))), grafana.PNG, nil
+}
+
+func (e *errClient) SearchDashboards(ctx context.Context, q grafana.SearchQuery) ([]grafana.DashboardRef, error) {
+	return nil, errors.New("Failed to search dashboards")
 }
 
 func TestReportErrorHandling(t *testing.T) {
@@ -194,8 +351,8 @@ func TestReportErrorHandling(t *testing.T) {
 		}()
 
 		c.Convey("When rendering images", func(c convey.C) {
-			dashboard, _ := gClient.GetDashboard("")
-			err := rep.renderPNGsParallel(dashboard)
+			dashboard, _ := gClient.GetDashboard(context.Background(), "")
+			err := rep.renderPanelsParallel(context.Background(), dashboard)
 
 			c.Convey("It shoud call getPanelPng once per panel", func(c convey.C) {
 				c.So(gClient.getPanelCallCount, convey.ShouldEqual, 9)
@@ -213,7 +370,7 @@ func TestReportErrorHandling(t *testing.T) {
 				c.So(err, convey.ShouldBeNil)
 			})
 
-			c.Convey("If any panels return errors, renderPNGsParralel should return the error message from one panel", func(c convey.C) {
+			c.Convey("If any panels return errors, renderPanelsParallel should return the error message from one panel", func(c convey.C) {
 				c.So(err, convey.ShouldNotBeNil)
 				c.So(err.Error(), convey.ShouldContainSubstring, "The second panel has convey.some problem")
 			})
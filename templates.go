@@ -0,0 +1,204 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/mlesar/grafana-report/grafana"
+)
+
+// Template is a named report template with its metadata.
+// Metadata is read from "%% key: value" TeX comment lines at the top of the template file.
+type Template struct {
+	Name        string
+	Description string
+	// Backend is the output backend the template is written for. Only latex is supported.
+	Backend string
+	// Layout is the panel layout the template is written for, grid or rows. It sets Options.GridLayout of reports
+	// using the template. Create the Grafana client with gridLayout set to GridLayout().
+	Layout  string
+	Version string
+
+	text     string
+	partials []string
+	// untrusted templates, such as templates posted for validation, cannot read the environment with env
+	untrusted bool
+}
+
+// Template layouts
+const (
+	GridLayout = "grid"
+	RowsLayout = "rows"
+)
+
+// GridLayout reports whether the template is written for the grid layout
+func (t *Template) GridLayout() bool {
+	return t.Layout == GridLayout
+}
+
+// TemplateRegistry holds named templates and the partials they share.
+// It contains the builtin templates default and grid, which can be overridden.
+type TemplateRegistry struct {
+	templates map[string]*Template
+	partials  []string
+}
+
+const partialsDir = "partials"
+
+// NewTemplateRegistry creates a TemplateRegistry containing the builtin templates only
+func NewTemplateRegistry() *TemplateRegistry {
+	r := &TemplateRegistry{templates: map[string]*Template{}}
+	r.add(newTemplate("default", defaultTemplate))
+	r.add(newTemplate("grid", defaultGridTemplate))
+	return r
+}
+
+// LoadTemplates creates a TemplateRegistry containing the builtin templates and the templates in dir.
+// Each dir/<name>.tex file is a template named name. Each dir/partials/*.tex file holds partials
+// defined with [[define "name"]]...[[end]], which any template can include with [[template "name" .]].
+func LoadTemplates(dir string) (*TemplateRegistry, error) {
+	r := NewTemplateRegistry()
+
+	files, err := filepath.Glob(filepath.Join(dir, partialsDir, "*.tex"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		text, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("reading partials %v: %w", file, err)
+		}
+		r.partials = append(r.partials, string(text))
+	}
+	//the builtin templates are added before the partials are read, and include them as well
+	for _, t := range r.templates {
+		t.partials = r.partials
+	}
+
+	files, err = filepath.Glob(filepath.Join(dir, "*.tex"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		if _, err := os.Stat(dir); err != nil {
+			return nil, fmt.Errorf("loading templates: %w", err)
+		}
+	}
+	for _, file := range files {
+		text, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("reading template %v: %w", file, err)
+		}
+		t := newTemplate(strings.TrimSuffix(filepath.Base(file), ".tex"), string(text))
+		if t.Backend != "latex" {
+			return nil, fmt.Errorf("template %v: unsupported backend %q", t.Name, t.Backend)
+		}
+		if t.Layout != GridLayout && t.Layout != RowsLayout {
+			return nil, fmt.Errorf("template %v: unknown layout %q", t.Name, t.Layout)
+		}
+		r.add(t)
+	}
+
+	//parse every template now, so that broken templates are reported when loading
+	for _, name := range r.Names() {
+		if _, err := r.templates[name].parse(); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (r *TemplateRegistry) add(t *Template) {
+	t.partials = r.partials
+	r.templates[t.Name] = t
+}
+
+// Get returns the template named name
+func (r *TemplateRegistry) Get(name string) (*Template, error) {
+	t, ok := r.templates[name]
+	if !ok {
+		return nil, fmt.Errorf("no template named %q", name)
+	}
+	return t, nil
+}
+
+// Names returns the sorted names of the templates
+func (r *TemplateRegistry) Names() []string {
+	var names []string
+	for name := range r.templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newTemplate(name string, text string) *Template {
+	t := &Template{Name: name, Backend: "latex", Layout: RowsLayout, text: text}
+	if name == "grid" {
+		t.Layout = GridLayout
+	}
+	for _, line := range strings.Split(strings.TrimLeft(text, "\n"), "\n") {
+		if !strings.HasPrefix(line, "%%") {
+			break
+		}
+		kv := strings.SplitN(strings.TrimPrefix(line, "%%"), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(kv[1])
+		switch strings.ToLower(strings.TrimSpace(kv[0])) {
+		case "description":
+			t.Description = value
+		case "backend":
+			t.Backend = value
+		case "layout":
+			t.Layout = value
+		case "version":
+			t.Version = value
+		}
+	}
+	return t
+}
+
+func (t *Template) parse() (*template.Template, error) {
+	return parseTemplate(t.Name, t.text, templateFuncs(grafana.Dashboard{}), t.partials...)
+}
+
+// parseTemplate parses a TeX template and its partials with the [[ ]] delimiters.
+// The builtin partials are parsed first, so that partials can override them.
+func parseTemplate(name string, text string, funcs template.FuncMap, partials ...string) (*template.Template, error) {
+	tmpl := template.New(name).Delims("[[", "]]").Funcs(funcs)
+	if _, err := tmpl.New(name + "-builtin").Parse(builtinPartials); err != nil {
+		return nil, fmt.Errorf("parsing builtin partials of template %v: %w", name, err)
+	}
+	for i, p := range partials {
+		if _, err := tmpl.New(fmt.Sprintf("%s-partials-%d", name, i)).Parse(p); err != nil {
+			return nil, fmt.Errorf("parsing partials of template %v: %w", name, err)
+		}
+	}
+	if _, err := tmpl.Parse(text); err != nil {
+		return nil, fmt.Errorf("parsing template %v: %w", name, err)
+	}
+	return tmpl, nil
+}
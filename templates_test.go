@@ -0,0 +1,119 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+const corporateTemplate = `%% description: Corporate weekly report
+%% layout: grid
+%% version: 2
+\documentclass{article}
+\begin{document}
+[[template "cover" .]]
+\end{document}
+`
+
+const coverPartial = `[[define "cover"]]\section*{Weekly: [[.Title]]}[[end]]`
+
+func TestTemplateRegistry(t *testing.T) {
+	convey.Convey("When loading templates from a directory", t, func(c convey.C) {
+		dir, err := ioutil.TempDir("", "templates")
+		c.So(err, convey.ShouldBeNil)
+		defer os.RemoveAll(dir)
+		c.So(os.MkdirAll(filepath.Join(dir, partialsDir), 0777), convey.ShouldBeNil)
+		c.So(ioutil.WriteFile(filepath.Join(dir, "corporate-weekly.tex"), []byte(corporateTemplate), 0666), convey.ShouldBeNil)
+		c.So(ioutil.WriteFile(filepath.Join(dir, partialsDir, "cover.tex"), []byte(coverPartial), 0666), convey.ShouldBeNil)
+
+		registry, err := LoadTemplates(dir)
+		c.So(err, convey.ShouldBeNil)
+
+		c.Convey("It should address templates and the builtin templates by name", func(c convey.C) {
+			c.So(registry.Names(), convey.ShouldResemble, []string{"corporate-weekly", "default", "grid"})
+			grid, err := registry.Get("grid")
+			c.So(err, convey.ShouldBeNil)
+			c.So(grid.GridLayout(), convey.ShouldBeTrue)
+			_, err = registry.Get("monthly")
+			c.So(err, convey.ShouldNotBeNil)
+		})
+
+		c.Convey("It should read the template metadata", func(c convey.C) {
+			tmpl, _ := registry.Get("corporate-weekly")
+			c.So(tmpl.Description, convey.ShouldEqual, "Corporate weekly report")
+			c.So(tmpl.Backend, convey.ShouldEqual, "latex")
+			c.So(tmpl.Layout, convey.ShouldEqual, GridLayout)
+			c.So(tmpl.Version, convey.ShouldEqual, "2")
+		})
+
+		c.Convey("Reports should include the partials of the template", func(c convey.C) {
+			tmpl, _ := registry.Get("corporate-weekly")
+			rep, tex, err := generateTestTeX(c, testDashboard(), Options{Template: tmpl})
+			defer rep.Clean()
+			c.So(err, convey.ShouldBeNil)
+			c.So(tex, convey.ShouldContainSubstring, `\section*{Weekly: My first dashboard}`)
+		})
+
+		c.Convey("Partials should override the builtin partials of the builtin templates", func(c convey.C) {
+			c.So(ioutil.WriteFile(filepath.Join(dir, partialsDir, "branding.tex"), []byte(`[[define "branding"]]%% ACME branding[[end]]`), 0666), convey.ShouldBeNil)
+			registry, err := LoadTemplates(dir)
+			c.So(err, convey.ShouldBeNil)
+			for _, name := range []string{"default", "grid"} {
+				tmpl, _ := registry.Get(name)
+				rep, tex, err := generateTestTeX(c, testDashboard(), Options{Template: tmpl})
+				defer rep.Clean()
+				c.So(err, convey.ShouldBeNil)
+				c.So(tex, convey.ShouldContainSubstring, "%% ACME branding")
+			}
+		})
+
+		c.Convey("Reports should lay panels out as their template does", func(c convey.C) {
+			tmpl, _ := registry.Get("corporate-weekly")
+			rep := newTestReport(c, &mockGrafanaClient{0, url.Values{}}, Options{Template: tmpl})
+			c.So(rep.opts.GridLayout, convey.ShouldBeTrue)
+			c.So(rep.opts.Page.Margin, convey.ShouldEqual, "0.5in")
+			tmpl, _ = registry.Get("default")
+			rep = newTestReport(c, &mockGrafanaClient{0, url.Values{}}, Options{Template: tmpl, GridLayout: true})
+			c.So(rep.opts.GridLayout, convey.ShouldBeFalse)
+		})
+
+		c.Convey("It should report templates that do not parse", func(c convey.C) {
+			c.So(ioutil.WriteFile(filepath.Join(dir, "broken.tex"), []byte(`[[template "missing" .]`), 0666), convey.ShouldBeNil)
+			_, err := LoadTemplates(dir)
+			c.So(err, convey.ShouldNotBeNil)
+			c.So(err.Error(), convey.ShouldContainSubstring, "broken")
+		})
+
+		c.Convey("It should report templates of unknown layouts", func(c convey.C) {
+			c.So(ioutil.WriteFile(filepath.Join(dir, "columns.tex"), []byte("%% layout: columns\n"), 0666), convey.ShouldBeNil)
+			_, err := LoadTemplates(dir)
+			c.So(err, convey.ShouldNotBeNil)
+			c.So(err.Error(), convey.ShouldContainSubstring, "columns")
+		})
+
+		c.Convey("It should fail on directories that do not exist", func(c convey.C) {
+			_, err := LoadTemplates(filepath.Join(dir, "missing"))
+			c.So(err, convey.ShouldNotBeNil)
+		})
+	})
+}
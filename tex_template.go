@@ -20,20 +20,32 @@ const defaultTemplate = `
 %use square brackets as golang text templating delimiters
 \documentclass{article}
 \usepackage{graphicx}
-\usepackage[margin=1in]{geometry}
+[[if .Captions]]\usepackage{caption}
+[[end]]\usepackage{xcolor}
+\definecolor{background}{HTML}{[[.Theme.Background]]}
+\definecolor{foreground}{HTML}{[[.Theme.Text]]}
+\usepackage{geometry}
+\geometry{[[.Page.Geometry]]}
+[[template "branding" .]]
+[[template "metadata" .]]
 
 \graphicspath{ {images/} }
 \begin{document}
+\pagecolor{background}
+\color{foreground}
 \title{[[.Title]] [[if .VariableValues]] \\ \large [[.VariableValues]] [[end]] [[if .Description]] \\ \small [[.Description]] [[end]]}
 \date{[[.FromFormatted]]\\to\\[[.ToFormatted]]}
 \maketitle
-\begin{center}
-[[range .Panels]][[if .IsSingleStat]]\begin{minipage}{0.3\textwidth}
-\includegraphics[width=\textwidth]{image[[.Id]]}
+[[if .TableOfContents]]\tableofcontents
+[[end]][[if .ListOfFigures]]\listoffigures
+[[end]]\begin{center}
+[[range $i, $p := .Panels]][[if $.BreakBefore $i]]\clearpage
+[[end]][[$.Bookmark .]][[if .IsSingleStat]]\begin{minipage}{0.3\textwidth}
+\includegraphics[width=\textwidth]{image[[.Id]]}[[template "caption" ($.WithPanel .)]]
 \end{minipage}
 [[else]]\par
 \vspace{0.5cm}
-\includegraphics[width=\textwidth]{image[[.Id]]}
+\includegraphics[width=\textwidth]{image[[.Id]]}[[template "caption" ($.WithPanel .)]]
 \par
 \vspace{0.5cm}
 [[end]][[end]]
@@ -46,24 +58,64 @@ const defaultGridTemplate = `
 %use square brackets as golang text templating delimiters
 \documentclass{article}
 \usepackage{graphicx}
-\usepackage[margin=0.5in]{geometry}
+[[if .Captions]]\usepackage{caption}
+[[end]]\usepackage{xcolor}
+\definecolor{background}{HTML}{[[.Theme.Background]]}
+\definecolor{foreground}{HTML}{[[.Theme.Text]]}
+\usepackage{geometry}
+\geometry{[[.Page.Geometry]]}
+[[template "branding" .]]
+[[template "metadata" .]]
 
 \graphicspath{ {images/} }
 \begin{document}
+\pagecolor{background}
+\color{foreground}
 \title{[[.Title]] [[if .VariableValues]] \\ \large [[.VariableValues]] [[end]] [[if .Description]] \\ \small [[.Description]] [[end]]}
 \date{[[.FromFormatted]]\\to\\[[.ToFormatted]]}
 \maketitle
-\begin{center}
-[[range .Panels]][[if .IsPartialWidth]]\begin{minipage}{[[.Width]]\textwidth}
-\includegraphics[width=\textwidth]{image[[.Id]]}
-\end{minipage}
-[[else]]\par
-\vspace{0.5cm}
-\includegraphics[width=\textwidth]{image[[.Id]]}
-\par
-\vspace{0.5cm}
-[[end]][[end]]
-
-\end{center}
+[[if .TableOfContents]]\tableofcontents
+[[end]][[if .ListOfFigures]]\listoffigures
+[[end]][[range .GridRows]][[if .PageBreak]]\clearpage
+[[end]]\noindent[[range .Cells]][[if .Gap]]\hspace*{[[.Width]]\textwidth}[[else]]\begin{minipage}[t]{[[.Width]]\textwidth}
+\centering
+[[range $i, $p := .Panels]][[if $i]]\par
+\vspace{0.2cm}
+[[end]][[$.Bookmark .]]\includegraphics[width=0.98\linewidth,height=0.85\textheight,keepaspectratio]{image[[.Id]]}[[template "caption" ($.WithPanel .)]]
+[[end]]\end{minipage}[[end]][[end]]\par
+\vspace{0.3cm}
+[[end]]
 \end{document}
 `
+
+// builtinPartials are available to every template, and can be overridden by the partials of a TemplateRegistry.
+// branding sets up the header, footer and watermark of Options.Branding. Include it in the preamble, after xcolor and geometry.
+// metadata loads hyperref for the bookmarks, and sets the PDF metadata. Include it at the end of the preamble.
+// caption captions a panel with Caption if Options.Captions is set, and otherwise shows its overridden time range.
+// Include it after the image of a panel with [[template "caption" ($.WithPanel .)]], and load the caption package for it.
+const builtinPartials = `
+[[define "branding"]][[with .Branding]][[if .IsSet]]
+\usepackage{fancyhdr}
+\usepackage{lastpage}
+\definecolor{accent}{HTML}{[[.Accent]]}
+\setlength{\headheight}{28pt}
+\pagestyle{fancy}
+\fancyhf{}
+\fancyhead[L]{[[if .Logo]]\raisebox{-0.3\height}{\includegraphics[height=24pt]{[[.Logo]]}}\hspace{0.5em}[[end]]\textcolor{accent}{\textbf{[[texescape .Company]]}}}
+\fancyhead[R]{[[texescape .Header]]}
+\fancyfoot[L]{[[texescape .Footer]]}
+\fancyfoot[R]{Page \thepage\ of \pageref{LastPage}}
+\renewcommand{\headrule}{{\color{accent}\hrule width\headwidth height\headrulewidth\vskip-\headrulewidth}}
+\fancypagestyle{plain}{}
+[[if .Watermark]]\usepackage{draftwatermark}
+\SetWatermarkText{[[texescape .Watermark]]}
+\SetWatermarkScale{0.6}
+\SetWatermarkColor[gray]{0.85}
+[[end]][[end]][[end]][[end]]
+[[define "caption"]][[if .Report.Captions]]
+\captionof{figure}[{[[default (printf "Panel %d" .Id) .Title]]}]{[[.Report.Caption .Panel]]}[[else if and .HasTimeOverride (not .HideTimeOverride)]][[with .EffectiveTimeRange .Report.TimeRange]]\\
+{\small [[.FromFormatted]] to [[.ToFormatted]]}[[end]][[end]][[end]]
+[[define "metadata"]]\usepackage[hidelinks,bookmarksopen]{hyperref}
+\hypersetup{pdftitle={[[.Title]]}, pdfsubject={[[.FromFormatted]] to [[.ToFormatted]]}, pdfauthor={[[texescape .Author]]}, pdfkeywords={[[.Keywords]]}}
+[[end]]
+`
@@ -0,0 +1,62 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/smartystreets/goconvey/convey"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracing(t *testing.T) {
+	convey.Convey("When tracing with an in-memory exporter", t, func(c convey.C) {
+		exporter := tracetest.NewInMemoryExporter()
+		tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+		tracer := tp.Tracer(instrumentationName)
+
+		c.Convey("Inject should add the W3C traceparent header", func(c convey.C) {
+			ctx, span := tracer.Start(context.Background(), "test")
+			h := http.Header{}
+			Inject(ctx, h)
+			span.End()
+			c.So(h.Get("traceparent"), convey.ShouldContainSubstring, span.SpanContext().TraceID().String())
+		})
+
+		c.Convey("End should record errors", func(c convey.C) {
+			_, span := tracer.Start(context.Background(), "failing")
+			End(span, errors.New("failed"))
+			spans := exporter.GetSpans()
+			c.So(spans, convey.ShouldHaveLength, 1)
+			c.So(spans[0].Status.Code, convey.ShouldEqual, codes.Error)
+			c.So(spans[0].Events[0].Name, convey.ShouldEqual, "exception")
+		})
+
+		c.Convey("End should end successful spans", func(c convey.C) {
+			_, span := tracer.Start(context.Background(), "succeeding")
+			End(span, nil)
+			spans := exporter.GetSpans()
+			c.So(spans, convey.ShouldHaveLength, 1)
+			c.So(spans[0].Status.Code, convey.ShouldEqual, codes.Unset)
+		})
+	})
+}
@@ -0,0 +1,363 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mlesar/grafana-report/grafana"
+	"github.com/pborman/uuid"
+)
+
+// Validation stages
+const (
+	ParseStage   = "parse"
+	ExecuteStage = "execute"
+	LaTeXStage   = "latex"
+)
+
+// Diagnostic describes a problem found while validating a template
+type Diagnostic struct {
+	// Stage is the validation stage that failed: parse, execute or latex
+	Stage   string `json:"stage"`
+	Message string `json:"message"`
+	// Template is the name of the template or partial the problem is in, if known
+	Template string `json:"template,omitempty"`
+	// TemplateLine is the line of the problem in Template, if known
+	TemplateLine int `json:"templateLine,omitempty"`
+	// LaTeXLine is the line of a LaTeX error in the generated report.tex
+	LaTeXLine int `json:"latexLine,omitempty"`
+	// Snippet is the offending template or LaTeX source line
+	Snippet string `json:"snippet,omitempty"`
+}
+
+// Validation is the result of validating a template
+type Validation struct {
+	Valid bool `json:"valid"`
+	// LaTeX reports whether the generated TeX was compiled. It is not if the template failed to parse or execute,
+	// or if pdflatex is not installed.
+	LaTeX       bool         `json:"latex"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
+// NewTemplate creates a template named name from the TeX template text and the partials it includes.
+// Metadata is read from the text as for LoadTemplates.
+func NewTemplate(name string, text string, partials ...string) *Template {
+	t := newTemplate(name, text)
+	t.partials = partials
+	return t
+}
+
+// ValidateTemplate checks that t parses, executes against dash in the time range tr, and compiles with LaTeX,
+// without rendering any panels. Panel images are replaced by placeholders and LaTeX runs in draft mode.
+// If dash is nil, a sample dashboard with a panel of each type is used. Empty bounds of tr default as for grafana.NewTimeRange.
+// Problems with the template are returned as diagnostics; the error reports failures to run the validation.
+func ValidateTemplate(ctx context.Context, t *Template, dash *grafana.Dashboard, tr grafana.TimeRange) (Validation, error) {
+	if dash == nil {
+		sample := sampleDashboard()
+		dash = &sample
+	}
+	tr = grafana.NewTimeRange(tr.From, tr.To)
+	v := Validation{}
+
+	funcs := templateFuncs(*dash)
+	if t.untrusted {
+		delete(funcs, "env")
+	}
+	tmpl, err := parseTemplate(t.Name, t.text, funcs, t.partials...)
+	if err != nil {
+		v.Diagnostics = append(v.Diagnostics, templateDiagnostic(ParseStage, err, t))
+		return v, nil
+	}
+	var tex bytes.Buffer
+	theme := Theme{}.withDefaults()
+	if err := tmpl.Execute(&tex, templateData{
+		Dashboard:  *dash,
+		TimeRange:  tr,
+		Theme:      theme,
+		Page:       Page{}.withDefaults(t.GridLayout()),
+		Branding:   Branding{}.withDefaults(theme),
+		PageBreaks: Continuous,
+		bookmarks:  &bookmarks{},
+	}); err != nil {
+		v.Diagnostics = append(v.Diagnostics, templateDiagnostic(ExecuteStage, err, t))
+		return v, nil
+	}
+
+	if _, err := exec.LookPath("pdflatex"); err != nil {
+		logger().Warn("pdflatex not found, skipping the LaTeX validation of the template", "template", t.Name)
+		v.Valid = true
+		return v, nil
+	}
+	diag, err := compileDraft(ctx, tex.Bytes(), dash.Panels)
+	if err != nil {
+		return v, err
+	}
+	v.LaTeX = true
+	if diag != nil {
+		diag.Template = t.Name
+		diag.TemplateLine = lineOf(t.text, diag.Snippet)
+		v.Diagnostics = append(v.Diagnostics, *diag)
+		return v, nil
+	}
+	v.Valid = true
+	return v, nil
+}
+
+// templateErrorPosition matches the template name and line of text/template errors,
+// e.g. template: report:12:5: executing "report" at <.Foo>: ...
+var templateErrorPosition = regexp.MustCompile(`template: ([^:\s]+):(\d+):(?:\d+:)? `)
+
+func templateDiagnostic(stage string, err error, t *Template) Diagnostic {
+	d := Diagnostic{Stage: stage, Message: err.Error()}
+	m := templateErrorPosition.FindStringSubmatch(err.Error())
+	if m == nil {
+		return d
+	}
+	d.Template = m[1]
+	d.TemplateLine, _ = strconv.Atoi(m[2])
+	d.Message = err.Error()[strings.LastIndex(err.Error(), m[0])+len(m[0]):]
+
+	source := t.text
+	if i := strings.TrimPrefix(d.Template, t.Name+"-partials-"); i != d.Template {
+		n, _ := strconv.Atoi(i)
+		if n < len(t.partials) {
+			source = t.partials[n]
+		}
+	}
+	d.Snippet = line(source, d.TemplateLine)
+	return d
+}
+
+// compileDraft compiles tex in draft mode in a temporary directory, with placeholder images for panels.
+// It returns a diagnostic if LaTeX fails.
+func compileDraft(ctx context.Context, tex []byte, panels []grafana.Panel) (*Diagnostic, error) {
+	dir := filepath.Join("tmp", uuid.New())
+	defer os.RemoveAll(dir)
+	if err := writePlaceholders(filepath.Join(dir, imgDir), panels); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, reportTexFile), tex, 0666); err != nil {
+		return nil, fmt.Errorf("writing tex file: %w", err)
+	}
+
+	cmd := latexCommand(ctx, dir, "-draftmode", "-interaction=nonstopmode")
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		return nil, nil
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || ctx.Err() != nil {
+		return nil, fmt.Errorf("calling LaTeX: %w", err)
+	}
+
+	latexErr := readLaTeXError(dir)
+	if latexErr == nil {
+		return &Diagnostic{Stage: LaTeXStage, Message: fmt.Sprintf("LaTeX failed: %v, output: %s", err, out)}, nil
+	}
+	d := &Diagnostic{Stage: LaTeXStage, Message: latexErr.Message, LaTeXLine: latexErr.Line, Snippet: line(string(tex), latexErr.Line)}
+	if latexErr.File != "" {
+		d.Message = fmt.Sprintf("%s in %s", d.Message, latexErr.File)
+	}
+	if latexErr.Hint != "" {
+		d.Message = fmt.Sprintf("%s (%s)", d.Message, latexErr.Hint)
+	}
+	return d, nil
+}
+
+// writePlaceholders writes a blank png image for each panel, named as renderPanel names panel images
+func writePlaceholders(dir string, panels []grafana.Panel) error {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return fmt.Errorf("creating img directory: %w", err)
+	}
+	img := image.NewGray(image.Rect(0, 0, 40, 30))
+	for i := range img.Pix {
+		img.Pix[i] = 0xcc
+	}
+	var placeholder bytes.Buffer
+	if err := png.Encode(&placeholder, img); err != nil {
+		return err
+	}
+	for _, p := range panels {
+		name := fmt.Sprintf("image%d.%s", p.Id, grafana.PNG)
+		if err := ioutil.WriteFile(filepath.Join(dir, name), placeholder.Bytes(), 0666); err != nil {
+			return fmt.Errorf("writing placeholder image: %w", err)
+		}
+	}
+	return nil
+}
+
+// line returns line n of text, counting from 1, or "" if there is no such line
+func line(text string, n int) string {
+	lines := strings.Split(text, "\n")
+	if n < 1 || n > len(lines) {
+		return ""
+	}
+	return strings.TrimSpace(lines[n-1])
+}
+
+// lineOf returns the line of text that is snippet, or 0 if there is none
+func lineOf(text string, snippet string) int {
+	if snippet == "" {
+		return 0
+	}
+	for i, l := range strings.Split(text, "\n") {
+		if strings.TrimSpace(l) == snippet {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// sampleDashboard is the dashboard templates are validated against when there is no real dashboard
+func sampleDashboard() grafana.Dashboard {
+	variables := url.Values{}
+	variables.Add("var-host", "host_1")
+	return grafana.NewDashboard([]byte(sampleDashJSON), variables)
+}
+
+const sampleDashJSON = `
+{"Dashboard":
+	{
+		"UID":"sample",
+		"Title":"Sample dashboard",
+		"Description":"A dashboard with a panel of each type, to validate templates",
+		"Tags":["sample", "validation"],
+		"Panels":
+		[
+			{"Type":"stat", "Id":1, "Title":"Uptime", "gridPos":{"h":4, "w":6, "x":0, "y":0}},
+			{"Type":"singlestat", "Id":2, "Title":"Requests", "gridPos":{"h":4, "w":6, "x":6, "y":0}},
+			{"Type":"text", "Id":3, "Title":"Notes & links", "gridPos":{"h":4, "w":12, "x":12, "y":0}},
+			{"Type":"graph", "Id":4, "Title":"CPU_usage", "gridPos":{"h":8, "w":24, "x":0, "y":4}},
+			{"Type":"table", "Id":5, "Title":"Top 10 % of hosts", "gridPos":{"h":8, "w":12, "x":0, "y":12}},
+			{"Type":"graph", "Id":6, "Title":"Yesterday", "gridPos":{"h":8, "w":12, "x":12, "y":12}, "timeShift":"1d"}
+		]
+	},
+"Meta":
+	{"Slug":"sample", "Version":1}
+}`
+
+// ValidationHandlerOptions configures the handler created by NewValidationHandler.
+// The zero value validates the templates of the registry against the sample dashboard only.
+type ValidationHandlerOptions struct {
+	// Client fetches the dashboard named by the dashboard query parameter. If nil, the parameter is rejected.
+	// Anyone who can reach the handler can validate against any dashboard Client can read,
+	// so give Client credentials that every caller is allowed to use, e.g. a Viewer token.
+	Client grafana.Client
+	// PostedTemplates enables validating a template posted as the request body. Posted templates cannot read
+	// the environment with env, and LaTeX cannot read or write files outside its build directory, but they
+	// still run LaTeX on the server: enable them only behind authentication.
+	PostedTemplates bool
+	// MaxTemplateSize is the maximum size of posted templates in bytes. It defaults to 1 MiB.
+	MaxTemplateSize int64
+}
+
+const defaultMaxTemplateSize = 1 << 20
+
+// NewValidationHandler creates an http.Handler that validates templates with ValidateTemplate and responds with the
+// Validation as JSON. Name a template of registry with the template query parameter, or, if opts.PostedTemplates
+// is set, POST the template text as the request body. The partials of registry are available to posted templates.
+// Templates are executed against the sample dashboard, or against the dashboard query parameter fetched with
+// opts.Client, in the time range of the from and to query parameters.
+func NewValidationHandler(registry *TemplateRegistry, opts ValidationHandlerOptions) http.Handler {
+	if opts.MaxTemplateSize <= 0 {
+		opts.MaxTemplateSize = defaultMaxTemplateSize
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "template validation requires POST", http.StatusMethodNotAllowed)
+			return
+		}
+		query := req.URL.Query()
+
+		var t *Template
+		if name := query.Get("template"); name != "" {
+			if registry == nil {
+				http.Error(w, fmt.Sprintf("no template named %q", name), http.StatusNotFound)
+				return
+			}
+			var err error
+			if t, err = registry.Get(name); err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+		} else {
+			if !opts.PostedTemplates {
+				http.Error(w, "validating posted templates is not enabled, name a template with the template parameter", http.StatusForbidden)
+				return
+			}
+			text, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, opts.MaxTemplateSize))
+			if err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					http.Error(w, fmt.Sprintf("template is larger than %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+					return
+				}
+				http.Error(w, fmt.Sprintf("reading template: %v", err), http.StatusBadRequest)
+				return
+			}
+			if len(bytes.TrimSpace(text)) == 0 {
+				http.Error(w, "no template to validate", http.StatusBadRequest)
+				return
+			}
+			var partials []string
+			if registry != nil {
+				partials = registry.partials
+			}
+			t = NewTemplate("template", string(text), partials...)
+			t.untrusted = true
+		}
+
+		var dash *grafana.Dashboard
+		if name := query.Get("dashboard"); name != "" {
+			if opts.Client == nil {
+				http.Error(w, "validating against dashboards is not configured", http.StatusBadRequest)
+				return
+			}
+			d, err := opts.Client.GetDashboard(req.Context(), name)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("error fetching dashboard %s: %v", name, err), http.StatusBadGateway)
+				return
+			}
+			dash = &d
+		}
+
+		v, err := ValidateTemplate(req.Context(), t, dash, grafana.TimeRange{From: query.Get("from"), To: query.Get("to")})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	})
+}
@@ -0,0 +1,178 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mlesar/grafana-report/grafana"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+// fakeLaTeX is a pdflatex stand in, that fails like pdflatex -file-line-error on the first line using \foo
+const fakeLaTeX = `#!/bin/sh
+file="$(eval echo \${$#})"
+[ -n "$LATEX_ARGS" ] && echo "$* openin_any=$openin_any openout_any=$openout_any" > "$LATEX_ARGS"
+ls images/image4.png > /dev/null || exit 2
+line=$(grep -n 'foo' "$file" | head -n 1 | cut -d: -f1)
+if [ -n "$line" ]; then
+	printf './report.tex:%s: Undefined control sequence.\nl.%s \\foo\n' "$line" "$line" > report.log
+	exit 1
+fi
+`
+
+func TestValidateTemplate(t *testing.T) {
+	convey.Convey("When validating templates", t, func(c convey.C) {
+		ctx := context.Background()
+		path := os.Getenv("PATH")
+		defer os.Setenv("PATH", path)
+		binDir, err := ioutil.TempDir("", "bin")
+		c.So(err, convey.ShouldBeNil)
+		defer os.RemoveAll(binDir)
+		ioutil.WriteFile(filepath.Join(binDir, "pdflatex"), []byte(fakeLaTeX), 0777)
+		os.Setenv("PATH", binDir+string(os.PathListSeparator)+path)
+
+		c.Convey("The builtin templates should be valid", func(c convey.C) {
+			for _, name := range NewTemplateRegistry().Names() {
+				tmpl, _ := NewTemplateRegistry().Get(name)
+				v, err := ValidateTemplate(ctx, tmpl, nil, grafana.TimeRange{})
+				c.So(err, convey.ShouldBeNil)
+				c.So(v.Valid, convey.ShouldBeTrue)
+				c.So(v.LaTeX, convey.ShouldBeTrue)
+				c.So(v.Diagnostics, convey.ShouldBeEmpty)
+			}
+		})
+
+		c.Convey("It should report the template line of parse errors", func(c convey.C) {
+			v, err := ValidateTemplate(ctx, NewTemplate("broken", "\\begin{document}\n[[if .Title]]\n\\end{document}"), nil, grafana.TimeRange{})
+			c.So(err, convey.ShouldBeNil)
+			c.So(v.Valid, convey.ShouldBeFalse)
+			c.So(v.Diagnostics, convey.ShouldHaveLength, 1)
+			c.So(v.Diagnostics[0].Stage, convey.ShouldEqual, ParseStage)
+			c.So(v.Diagnostics[0].Template, convey.ShouldEqual, "broken")
+			c.So(v.Diagnostics[0].TemplateLine, convey.ShouldEqual, 3)
+		})
+
+		c.Convey("It should report the template line and snippet of execution errors in partials", func(c convey.C) {
+			tmpl := NewTemplate("report", `[[template "cover" .]]`, "[[define \"cover\"]]\n\\title{[[.Titel]]}[[end]]")
+			v, err := ValidateTemplate(ctx, tmpl, nil, grafana.TimeRange{})
+			c.So(err, convey.ShouldBeNil)
+			c.So(v.Diagnostics, convey.ShouldHaveLength, 1)
+			c.So(v.Diagnostics[0].Stage, convey.ShouldEqual, ExecuteStage)
+			c.So(v.Diagnostics[0].Template, convey.ShouldEqual, "report-partials-0")
+			c.So(v.Diagnostics[0].TemplateLine, convey.ShouldEqual, 2)
+			c.So(v.Diagnostics[0].Snippet, convey.ShouldEqual, `\title{[[.Titel]]}[[end]]`)
+			c.So(v.Diagnostics[0].Message, convey.ShouldContainSubstring, "Titel")
+		})
+
+		c.Convey("It should report the LaTeX line and snippet of LaTeX errors", func(c convey.C) {
+			tmpl := NewTemplate("report", "\\begin{document}\n[[.Title]]\n\\foo\n\\end{document}")
+			v, err := ValidateTemplate(ctx, tmpl, nil, grafana.TimeRange{})
+			c.So(err, convey.ShouldBeNil)
+			c.So(v.Valid, convey.ShouldBeFalse)
+			c.So(v.LaTeX, convey.ShouldBeTrue)
+			c.So(v.Diagnostics, convey.ShouldResemble, []Diagnostic{{
+				Stage: LaTeXStage, Message: "Undefined control sequence", Template: "report", TemplateLine: 3, LaTeXLine: 3, Snippet: `\foo`,
+			}})
+		})
+
+		c.Convey("It should skip LaTeX if pdflatex is not installed", func(c convey.C) {
+			os.Setenv("PATH", "")
+			v, err := ValidateTemplate(ctx, NewTemplate("report", `\foo`), nil, grafana.TimeRange{})
+			c.So(err, convey.ShouldBeNil)
+			c.So(v.Valid, convey.ShouldBeTrue)
+			c.So(v.LaTeX, convey.ShouldBeFalse)
+		})
+
+		c.Convey("The handler should validate posted templates against dashboards if enabled", func(c convey.C) {
+			handler := NewValidationHandler(NewTemplateRegistry(), ValidationHandlerOptions{Client: &mockGrafanaClient{0, url.Values{}}, PostedTemplates: true})
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/validate?dashboard=testDash", strings.NewReader(`[[.Titel]]`)))
+			c.So(rec.Code, convey.ShouldEqual, http.StatusOK)
+			var v Validation
+			c.So(json.NewDecoder(rec.Body).Decode(&v), convey.ShouldBeNil)
+			c.So(v.Valid, convey.ShouldBeFalse)
+			c.So(v.Diagnostics[0].Stage, convey.ShouldEqual, ExecuteStage)
+		})
+
+		c.Convey("Posted templates should not read the environment", func(c convey.C) {
+			os.Setenv("REPORT_TEST_SECRET", "secret")
+			defer os.Unsetenv("REPORT_TEST_SECRET")
+			handler := NewValidationHandler(NewTemplateRegistry(), ValidationHandlerOptions{PostedTemplates: true})
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/validate", strings.NewReader(`\foo [[env "REPORT_TEST_SECRET"]]`)))
+			c.So(rec.Code, convey.ShouldEqual, http.StatusOK)
+			c.So(rec.Body.String(), convey.ShouldNotContainSubstring, "secret")
+			c.So(rec.Body.String(), convey.ShouldContainSubstring, `"stage":"parse"`)
+		})
+
+		c.Convey("The handler should limit the size of posted templates", func(c convey.C) {
+			handler := NewValidationHandler(NewTemplateRegistry(), ValidationHandlerOptions{PostedTemplates: true, MaxTemplateSize: 8})
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/validate", strings.NewReader(`[[.Title]] and more`)))
+			c.So(rec.Code, convey.ShouldEqual, http.StatusRequestEntityTooLarge)
+		})
+
+		c.Convey("The handler should only validate registered templates by default", func(c convey.C) {
+			handler := NewValidationHandler(NewTemplateRegistry(), ValidationHandlerOptions{})
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/validate", strings.NewReader(`[[.Title]]`)))
+			c.So(rec.Code, convey.ShouldEqual, http.StatusForbidden)
+
+			rec = httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/validate?template=grid&dashboard=testDash", nil))
+			c.So(rec.Code, convey.ShouldEqual, http.StatusBadRequest)
+		})
+
+		c.Convey("LaTeX should run without shell escape and file access outside its directory", func(c convey.C) {
+			args := filepath.Join(binDir, "args")
+			os.Setenv("LATEX_ARGS", args)
+			defer os.Unsetenv("LATEX_ARGS")
+			_, err := ValidateTemplate(ctx, NewTemplate("report", `\input{/etc/passwd}`), nil, grafana.TimeRange{})
+			c.So(err, convey.ShouldBeNil)
+			out, _ := ioutil.ReadFile(args)
+			c.So(string(out), convey.ShouldContainSubstring, "-no-shell-escape")
+			c.So(string(out), convey.ShouldContainSubstring, "-file-line-error")
+			c.So(string(out), convey.ShouldContainSubstring, "openin_any=p openout_any=p")
+		})
+
+		c.Convey("The handler should validate registered templates", func(c convey.C) {
+			handler := NewValidationHandler(NewTemplateRegistry(), ValidationHandlerOptions{})
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/validate?template=grid", nil))
+			c.So(rec.Code, convey.ShouldEqual, http.StatusOK)
+			c.So(rec.Body.String(), convey.ShouldContainSubstring, `"valid":true`)
+
+			rec = httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/validate?template=missing", nil))
+			c.So(rec.Code, convey.ShouldEqual, http.StatusNotFound)
+
+			rec = httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/validate?template=grid", nil))
+			c.So(rec.Code, convey.ShouldEqual, http.StatusMethodNotAllowed)
+		})
+	})
+}